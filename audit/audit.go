@@ -0,0 +1,127 @@
+// Package audit records data modifications made through lazyadmin.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited action.
+const (
+	OutcomeOK      = "ok"
+	OutcomeError   = "error"
+	OutcomePending = "pending" // staged in a transaction, not yet committed
+)
+
+// Entry is one audited action.
+type Entry struct {
+	Time       time.Time              `json:"time"`
+	User       string                 `json:"user"`
+	Connection string                 `json:"connection"`
+	Action     string                 `json:"action"` // INSERT, UPDATE, DELETE, UNDO <kind>, SQL, COMMIT, ROLLBACK or REVEAL
+	Table      string                 `json:"table,omitempty"`
+	Key        map[string]interface{} `json:"key,omitempty"`
+	Before     map[string]interface{} `json:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty"`
+	SQL        string                 `json:"sql,omitempty"`
+	Args       []interface{}          `json:"args,omitempty"`
+	Outcome    string                 `json:"outcome"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Sink stores audit entries.
+type Sink interface {
+	Write(e Entry) error
+}
+
+// Log writes e to every sink and returns their errors joined.
+func Log(e Entry, sinks ...Sink) error {
+	var errs []error
+	for _, s := range sinks {
+		if err := s.Write(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Image copies a row for an entry, turning raw bytes into text so it reads
+// as the table showed it rather than as base64.
+func Image(row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	img := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		img[k] = v
+	}
+	return img
+}
+
+// CurrentUser returns the name of the OS user running lazyadmin.
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// DefaultPath returns where the local audit log lives by default:
+// $XDG_STATE_HOME/lazyadmin/audit.jsonl, or ~/.local/state/... without it.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "lazyadmin", "audit.jsonl")
+}
+
+// fileMu serializes appends from this process; O_APPEND keeps lines from
+// separate processes whole.
+var fileMu sync.Mutex
+
+// FileSink appends entries to a local file as JSON lines. The file is only
+// ever appended to.
+type FileSink struct {
+	Path string
+}
+
+func (s FileSink) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	line = append(line, '\n')
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("audit log: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "audit.jsonl")
+	sink := FileSink{Path: path}
+
+	entries := []Entry{
+		{Time: time.Now(), User: "alice", Action: "UPDATE", Table: "users", Key: map[string]interface{}{"id": 1}, Outcome: OutcomeOK},
+		{Time: time.Now(), User: "alice", Action: "DELETE", Table: "users", Outcome: OutcomeError, Error: "no rows"},
+	}
+	for _, e := range entries {
+		if err := sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fi, _ := f.Stat(); fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	var got []Entry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[0].Action != "UPDATE" || got[1].Error != "no rows" {
+		t.Errorf("entries = %+v", got)
+	}
+}
+
+func TestTableSink(t *testing.T) {
+	conn, err := db.Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.DB.Exec(`CREATE TABLE audit_log (
+		occurred_at TIMESTAMP, os_user TEXT, connection TEXT, action TEXT,
+		table_name TEXT, row_key TEXT, before_image TEXT, after_image TEXT,
+		sql_text TEXT, outcome TEXT, error TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := TableSink{DB: conn.DB, Driver: "sqlite", Table: "audit_log"}
+	err = sink.Write(Entry{
+		Time:    time.Now(),
+		User:    "alice",
+		Action:  "UPDATE",
+		Table:   "users",
+		Key:     map[string]interface{}{"id": 1},
+		Before:  Image(map[string]interface{}{"email": []byte("a@example.com")}),
+		SQL:     "UPDATE users SET email = ? WHERE id = ?",
+		Outcome: OutcomeOK,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var key, before string
+	var after *string
+	if err := conn.DB.QueryRow("SELECT row_key, before_image, after_image FROM audit_log").Scan(&key, &before, &after); err != nil {
+		t.Fatal(err)
+	}
+	if key != `{"id":1}` || before != `{"email":"a@example.com"}` || after != nil {
+		t.Errorf("row_key = %s, before_image = %s, after_image = %v", key, before, after)
+	}
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// TableSink inserts entries into an audit table in the audited database
+// itself. The table needs the columns occurred_at, os_user, connection,
+// action, table_name, row_key, before_image, after_image, sql_text,
+// outcome and error; the images and key are stored as JSON text.
+type TableSink struct {
+	DB     db.Querier
+	Driver string
+	Table  string
+}
+
+func (s TableSink) Write(e Entry) error {
+	key, err := jsonText(e.Key)
+	if err != nil {
+		return err
+	}
+	before, err := jsonText(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := jsonText(e.After)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.BuildInsert(s.Driver, s.Table, map[string]interface{}{
+		"occurred_at":  e.Time.UTC(),
+		"os_user":      e.User,
+		"connection":   e.Connection,
+		"action":       e.Action,
+		"table_name":   e.Table,
+		"row_key":      key,
+		"before_image": before,
+		"after_image":  after,
+		"sql_text":     e.SQL,
+		"outcome":      e.Outcome,
+		"error":        e.Error,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.Exec(s.DB); err != nil {
+		return fmt.Errorf("audit table %s: %w", s.Table, err)
+	}
+	return nil
+}
+
+// jsonText encodes v for a text column, with NULL for nothing.
+func jsonText(v map[string]interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("audit table: %w", err)
+	}
+	return string(b), nil
+}
@@ -2,17 +2,55 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/qyinm/lazyadmin/mask"
 	"gopkg.in/yaml.v3"
 )
 
 type SSHConfig struct {
+	Alias      string `yaml:"alias"` // Host entry in ~/.ssh/config; fills in unset fields below
 	Host       string `yaml:"host"`
 	Port       int    `yaml:"port"`
 	User       string `yaml:"user"`
 	Password   string `yaml:"password"`
 	PrivateKey string `yaml:"private_key"`
+	KnownHosts string `yaml:"known_hosts"` // defaults to ~/.ssh/known_hosts
+	HostKey    string `yaml:"host_key"`    // pinned key ("ssh-ed25519 AAAA...") or "SHA256:..." fingerprint
+
+	// Jump lists the hosts to go through before Host, in order, like
+	// ssh's ProxyJump. Each hop authenticates with its own settings.
+	Jump []SSHConfig `yaml:"jump,omitempty"`
+}
+
+// PoolConfig sizes the connection pool. Zero values keep database/sql's
+// defaults.
+type PoolConfig struct {
+	MaxOpen     int           `yaml:"max_open,omitempty"`
+	MaxIdle     int           `yaml:"max_idle,omitempty"`
+	MaxLifetime time.Duration `yaml:"max_lifetime,omitempty"`
+	MaxIdleTime time.Duration `yaml:"max_idle_time,omitempty"`
+}
+
+// SessionConfig is applied to every new session in the pool. Connecting
+// fails if a setting isn't available for the connection's driver.
+type SessionConfig struct {
+	StatementTimeout time.Duration `yaml:"statement_timeout,omitempty"` // Postgres, MySQL
+	LockTimeout      time.Duration `yaml:"lock_timeout,omitempty"`      // Postgres, MySQL
+	SearchPath       string        `yaml:"search_path,omitempty"`       // Postgres
+	SQLMode          string        `yaml:"sql_mode,omitempty"`          // MySQL
+	TimeZone         string        `yaml:"time_zone,omitempty"`         // Postgres, MySQL
+	BusyTimeout      time.Duration `yaml:"busy_timeout,omitempty"`      // SQLite
+	ForeignKeys      *bool         `yaml:"foreign_keys,omitempty"`      // SQLite
+	JournalMode      string        `yaml:"journal_mode,omitempty"`      // SQLite
+
+	// InitSQL runs after the settings above, in order.
+	InitSQL []string `yaml:"init_sql,omitempty"`
 }
 
 type DatabaseConfig struct {
@@ -24,8 +62,64 @@ type DatabaseConfig struct {
 	Password string     `yaml:"password"`
 	Name     string     `yaml:"name"`
 	SSLMode  string     `yaml:"ssl_mode"`
+	Socket   string     `yaml:"socket"` // Unix socket (for Postgres, or its directory) instead of host/port
 	Path     string     `yaml:"path"`
 	SSH      *SSHConfig `yaml:"ssh"`
+
+	// ReadOnly opens every session read-only and disables editing.
+	ReadOnly bool `yaml:"read_only,omitempty"`
+
+	// Environment tags the connection: dev, staging, prod or any custom
+	// name. Production connections get extra confirmation before writes.
+	Environment string `yaml:"environment,omitempty"`
+
+	// AuditTable, if set, receives a copy of every audit entry for this
+	// connection, in the connection's own database.
+	AuditTable string `yaml:"audit_table,omitempty"`
+
+	// VersionColumn names a column, such as updated_at, that changes on
+	// every write. Edits to tables that have it check only that column for
+	// concurrent changes instead of every edited column.
+	VersionColumn string `yaml:"version_column,omitempty"`
+
+	// Masks redact sensitive columns in everything this connection shows.
+	Masks []Mask `yaml:"masks,omitempty"`
+
+	Pool    *PoolConfig    `yaml:"pool,omitempty"`
+	Session *SessionConfig `yaml:"session,omitempty"`
+
+	// Source is the config file this connection was loaded from.
+	// Save writes the connection back to that file; an empty Source
+	// means the connection is new and belongs to the project file.
+	Source string `yaml:"-"`
+
+	// fileValues holds the connection as it appeared on disk before
+	// LAZYADMIN_* environment overrides were applied, so that Save never
+	// persists values that only came from the environment.
+	fileValues *DatabaseConfig
+
+	// shadows holds the entries with the same label in earlier layers that
+	// this one overrides, oldest first. Save writes them back unchanged, as
+	// it does ignored, the user layer's entry when the role ignores it.
+	shadows []DatabaseConfig
+	ignored *DatabaseConfig
+}
+
+// IsProduction reports whether c is tagged as a production environment.
+func (c DatabaseConfig) IsProduction() bool {
+	switch strings.ToLower(c.Environment) {
+	case "prod", "production":
+		return true
+	}
+	return false
+}
+
+// Mask redacts a column. With Table set it applies only to results that
+// read that table; otherwise the column is masked wherever it appears.
+type Mask struct {
+	Table  string `yaml:"table,omitempty"`
+	Column string `yaml:"column"`
+	Mode   string `yaml:"mode,omitempty"` // full (the default), partial, hash or hide
 }
 
 type View struct {
@@ -36,65 +130,86 @@ type View struct {
 
 type Config struct {
 	ProjectName string           `yaml:"project_name"`
-	Database    DatabaseConfig   `yaml:"database"` // Deprecated: used for backward compatibility
+	Include     []string         `yaml:"include"`
+	Default     string           `yaml:"default"`   // label of the connection to open at startup
+	AuditLog    string           `yaml:"audit_log"` // local audit log; defaults to audit.DefaultPath
+	Database    DatabaseConfig   `yaml:"database"`  // Deprecated: used for backward compatibility
 	Connections []DatabaseConfig `yaml:"connections"`
 	Views       []View           `yaml:"views"`
+
+	// Roles and Users limit what each OS user may do; see Config.Access.
+	// Only shared layers set them: the per-user file can't.
+	Roles map[string]*Role  `yaml:"roles,omitempty"`
+	Users map[string]string `yaml:"users,omitempty"` // OS user name to role
+
+	// files lists every layer that contributed to this config, in load order.
+	files []string
+	// userFile is the per-user layer, if it was loaded, and sharedViews
+	// the views as they were before it; see ignoreUserLayer.
+	userFile    string
+	sharedViews []View
+	// connFiles records the layers that defined connections when loaded,
+	// so Save can rewrite a layer whose connections were all removed.
+	connFiles map[string]bool
 }
 
-// Load reads and parses the configuration file at the given path.
+// Load reads the configuration at the given path together with its layers.
+//
+// Layers are applied in order of increasing precedence: files listed under
+// `include:` (relative to the including file), the project file itself,
+// the per-user file returned by UserConfigPath, and finally LAZYADMIN_*
+// environment variables. Connections are merged by label and views by
+// title; a later layer replaces an earlier entry with the same name and
+// appends anything new. Only the project file is required to exist.
+//
 // It handles backward compatibility for single database configurations
 // and validates all database connections, setting defaults where needed.
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
+	cfg := &Config{connFiles: make(map[string]bool)}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := cfg.loadLayer(path, nil); err != nil {
 		return nil, err
 	}
 
-	// Backward compatibility: If Connections is empty but Database is present, use it.
-	if len(cfg.Connections) == 0 && cfg.Database.Driver != "" {
-		if cfg.Database.Label == "" {
-			cfg.Database.Label = "Default"
+	if userPath := UserConfigPath(); userPath != "" && !sameFile(userPath, path) {
+		roles, users := maps.Clone(cfg.Roles), maps.Clone(cfg.Users)
+		masks := cfg.masks()
+		cfg.sharedViews = slices.Clone(cfg.Views)
+		if _, err := os.Stat(userPath); err == nil {
+			if err := cfg.loadLayer(userPath, nil); err != nil {
+				return nil, err
+			}
+			cfg.userFile = absPath(userPath)
+		} else if !os.IsNotExist(err) {
+			return nil, err
 		}
-		cfg.Connections = append(cfg.Connections, cfg.Database)
+		// Nobody grants themselves a role, or unmasks a column, from their
+		// own file.
+		cfg.Roles, cfg.Users = roles, users
+		cfg.keepMasks(masks)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
 	}
 
 	if len(cfg.Connections) == 0 {
 		return nil, fmt.Errorf("no database connections defined")
 	}
 
+	if err := cfg.validateRoles(); err != nil {
+		return nil, err
+	}
+
 	// Validate and set defaults for all connections
 	for i := range cfg.Connections {
-		c := &cfg.Connections[i]
-
-		switch c.Driver {
-		case "sqlite", "sqlite3", "postgres", "postgresql", "mysql":
-		case "":
-			return nil, fmt.Errorf("connection %d: database driver is required", i)
-		default:
-			return nil, fmt.Errorf("connection %d: unsupported database driver %q", i, c.Driver)
-		}
-
-		if c.Port == 0 {
-			switch c.Driver {
-			case "postgres", "postgresql":
-				c.Port = 5432
-			case "mysql":
-				c.Port = 3306
-			}
-		}
-
-		if c.SSH != nil && c.SSH.Port == 0 {
-			c.SSH.Port = 22
+		if err := cfg.Connections[i].normalize(i); err != nil {
+			return nil, err
 		}
+	}
 
-		if c.Label == "" {
-			c.Label = fmt.Sprintf("Connection %d", i+1)
-		}
+	if cfg.Default != "" && cfg.connectionIndex(cfg.Default) < 0 {
+		return nil, fmt.Errorf("default connection %q is not defined", cfg.Default)
 	}
 
 	// Ensure the deprecated field matches the first connection for any legacy code access
@@ -102,12 +217,105 @@ func Load(path string) (*Config, error) {
 		cfg.Database = cfg.Connections[0]
 	}
 
-	return &cfg, nil
+	return cfg, nil
+}
+
+// normalize validates the connection at index i of the config and fills
+// in defaults.
+func (c *DatabaseConfig) normalize(i int) error {
+	switch c.Driver {
+	case "sqlite", "sqlite3", "postgres", "postgresql", "mysql":
+	case "":
+		return fmt.Errorf("connection %d: database driver is required", i)
+	default:
+		return fmt.Errorf("connection %d: unsupported database driver %q", i, c.Driver)
+	}
+
+	if c.Port == 0 {
+		switch c.Driver {
+		case "postgres", "postgresql":
+			c.Port = 5432
+		case "mysql":
+			c.Port = 3306
+		}
+	}
+
+	if c.SSH != nil && c.SSH.Port == 0 && c.SSH.Alias == "" {
+		c.SSH.Port = 22
+	}
+
+	if c.Label == "" {
+		c.Label = fmt.Sprintf("Connection %d", i+1)
+	}
+
+	for j := range c.Masks {
+		mk := &c.Masks[j]
+		if mk.Column == "" {
+			return fmt.Errorf("connection %s: mask %d: column is required", c.Label, j+1)
+		}
+		if mk.Mode == "" {
+			mk.Mode = mask.Full
+		} else if !slices.Contains(mask.Modes, mk.Mode) {
+			return fmt.Errorf("connection %s: mask on %s: unknown mode %q (want one of %v)", c.Label, mk.Column, mk.Mode, mask.Modes)
+		}
+	}
+	return nil
+}
+
+// Files returns every config file that contributed to cfg, in load order.
+func (cfg *Config) Files() []string {
+	return append([]string(nil), cfg.files...)
 }
 
 // Save writes the configuration to the given file path.
 // Uses atomic write (temp file + rename) to prevent corruption.
+//
+// For a config produced by Load, each connection is written back only to
+// the layer it came from and every other key in that file is preserved.
+// Entries a later layer overrides stay in their own layer as they were.
+// Connections without a Source are written to path.
 func Save(path string, cfg *Config) error {
+	if len(cfg.files) == 0 {
+		return saveFlat(path, cfg)
+	}
+
+	byFile := make(map[string][]DatabaseConfig)
+	var order []string
+	add := func(src string, c DatabaseConfig) {
+		if src == "" {
+			src = path
+		}
+		src = absPath(src)
+		if _, ok := byFile[src]; !ok {
+			order = append(order, src)
+		}
+		byFile[src] = append(byFile[src], c)
+	}
+	for _, c := range cfg.Connections {
+		for _, s := range c.shadows {
+			add(s.Source, s)
+		}
+		add(c.Source, c.persisted())
+		if c.ignored != nil {
+			add(c.ignored.Source, *c.ignored)
+		}
+	}
+	for _, f := range cfg.files {
+		if _, ok := byFile[f]; !ok && cfg.connFiles[f] {
+			order = append(order, f)
+			byFile[f] = []DatabaseConfig{}
+		}
+	}
+
+	for _, f := range order {
+		if err := saveConnections(f, byFile[f]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveFlat(path string, cfg *Config) error {
 	type configToSave struct {
 		ProjectName string           `yaml:"project_name"`
 		Connections []DatabaseConfig `yaml:"connections"`
@@ -124,7 +332,54 @@ func Save(path string, cfg *Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
+	return writeAtomic(path, data)
+}
+
+// saveConnections replaces the connections of a single layer file, keeping
+// the rest of the document (views, includes, comments) as it is on disk.
+func saveConnections(path string, conns []DatabaseConfig) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create config directory: %w", err)
+		}
+	default:
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level must be a mapping", path)
+	}
+
+	var connNode yaml.Node
+	if err := connNode.Encode(conns); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	setMappingValue(root, "connections", &connNode)
+	// The legacy single `database:` entry now lives in `connections:`.
+	deleteMappingValue(root, "database")
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return writeAtomic(path, out)
+}
 
+func writeAtomic(path string, data []byte) error {
 	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
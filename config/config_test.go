@@ -0,0 +1,423 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "home", "config.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", userPath)
+
+	writeFile(t, filepath.Join(dir, "shared", "views.yaml"), `
+views:
+  - title: "Users"
+    query: "SELECT * FROM users"
+  - title: "Payments"
+    query: "SELECT * FROM payments"
+connections:
+  - label: "Shared"
+    driver: sqlite
+    path: shared.db
+`)
+	writeFile(t, filepath.Join(dir, "admin.yaml"), `
+project_name: "Team"
+include:
+  - shared/views.yaml
+views:
+  - title: "Payments"
+    query: "SELECT id FROM payments"
+connections:
+  - label: "Local"
+    driver: postgres
+    host: localhost
+`)
+	writeFile(t, userPath, `
+connections:
+  - label: "Local"
+    driver: postgres
+    host: my-laptop
+  - label: "Mine"
+    driver: sqlite
+    path: mine.db
+`)
+	t.Setenv("LAZYADMIN_CONN_LOCAL_PASSWORD", "from-env")
+
+	cfg, err := Load(filepath.Join(dir, "admin.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Views) != 2 || cfg.Views[1].Query != "SELECT id FROM payments" {
+		t.Errorf("project file should override included view, got %+v", cfg.Views)
+	}
+
+	labels := make([]string, len(cfg.Connections))
+	for i, c := range cfg.Connections {
+		labels[i] = c.Label
+	}
+	if got := strings.Join(labels, ","); got != "Shared,Local,Mine" {
+		t.Fatalf("connections = %s, want Shared,Local,Mine", got)
+	}
+
+	local := cfg.Connections[1]
+	if local.Host != "my-laptop" {
+		t.Errorf("user layer should override project host, got %q", local.Host)
+	}
+	if local.Password != "from-env" {
+		t.Errorf("env layer should override password, got %q", local.Password)
+	}
+	if local.Source != userPath {
+		t.Errorf("Source = %q, want %q", local.Source, userPath)
+	}
+	if local.Port != 5432 {
+		t.Errorf("default port not applied, got %d", local.Port)
+	}
+}
+
+func TestSaveWritesToSourceLayer(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "home", "config.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", userPath)
+
+	projectPath := filepath.Join(dir, "admin.yaml")
+	writeFile(t, projectPath, `
+project_name: "Team"
+# shared views
+views:
+  - title: "Users"
+    query: "SELECT * FROM users"
+database:
+  driver: sqlite
+  path: team.db
+`)
+	writeFile(t, userPath, `
+connections:
+  - label: "Mine"
+    driver: postgres
+    password: on-disk
+`)
+	t.Setenv("LAZYADMIN_CONN_MINE_PASSWORD", "from-env")
+
+	cfg, err := Load(projectPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	cfg.Connections = append(cfg.Connections, DatabaseConfig{Label: "New", Driver: "sqlite", Path: "new.db"})
+	if err := Save(projectPath, cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	project, _ := os.ReadFile(projectPath)
+	user, _ := os.ReadFile(userPath)
+
+	if !strings.Contains(string(project), "# shared views") {
+		t.Errorf("project file lost its comments:\n%s", project)
+	}
+	if !strings.Contains(string(project), "label: New") || strings.Contains(string(project), "Mine") {
+		t.Errorf("project file has wrong connections:\n%s", project)
+	}
+	if strings.Contains(string(project), "database:") {
+		t.Errorf("legacy database key should be migrated:\n%s", project)
+	}
+	if strings.Contains(string(user), "New") {
+		t.Errorf("new connection leaked into user layer:\n%s", user)
+	}
+	if strings.Contains(string(user), "from-env") || !strings.Contains(string(user), "on-disk") {
+		t.Errorf("env override was persisted:\n%s", user)
+	}
+
+	reloaded, err := Load(projectPath)
+	if err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	if len(reloaded.Connections) != 3 {
+		t.Errorf("reloaded %d connections, want 3", len(reloaded.Connections))
+	}
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+
+	writeFile(t, filepath.Join(dir, "a.yaml"), "include: [b.yaml]\n")
+	writeFile(t, filepath.Join(dir, "b.yaml"), "include: [a.yaml]\n")
+
+	_, err := Load(filepath.Join(dir, "a.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Fatalf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "home", "config.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", userPath)
+
+	writeFile(t, filepath.Join(dir, "admin.yaml"), `
+connections:
+  - label: "Production"
+    driver: sqlite
+    path: prod.db
+    read_only: true
+views:
+  - title: "Active Users"
+    query: "SELECT id FROM users"
+roles:
+  admin: {}
+  support:
+    views: ["Active Users"]
+    tables: []
+    actions: [read]
+  staging:
+    connections: ["Staging"]
+  ops:
+    connections: ["Staging"]
+    actions: ["*"]
+  default:
+    views: ["Active Users"]
+    actions: [read]
+users:
+  alice: admin
+  bob: support
+`)
+	writeFile(t, userPath, `
+users:
+  bob: admin
+connections:
+  - label: "Production"
+    driver: sqlite
+    path: prod.db
+views:
+  - title: "Active Users"
+    query: "SELECT * FROM payments"
+`)
+
+	load := func() *Config {
+		t.Helper()
+		cfg, err := Load(filepath.Join(dir, "admin.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	cfg := load()
+
+	bob, err := cfg.Access("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Role != "support" {
+		t.Errorf("bob's role = %q, want support: the user layer mustn't grant roles", bob.Role)
+	}
+	if err := bob.View("Active Users"); err != nil {
+		t.Errorf("support denied its view: %v", err)
+	}
+	if err := bob.View("Payments"); !errors.Is(err, ErrDenied) {
+		t.Errorf("View(Payments) = %v, want denied", err)
+	}
+	if err := bob.Action(ActionRead, "users"); !errors.Is(err, ErrDenied) {
+		t.Errorf("an empty tables list should allow none, got %v", err)
+	}
+	if err := bob.Action(ActionDelete, ""); !errors.Is(err, ErrDenied) {
+		t.Errorf("Action(delete) = %v, want denied", err)
+	}
+	if err := bob.Connection("Production"); err != nil {
+		t.Errorf("leaving connections out should allow all, got %v", err)
+	}
+
+	// A restricted role runs the shared view and connection, not the user
+	// file's versions of them.
+	if q := cfg.Views[0].Query; q != "SELECT id FROM users" {
+		t.Errorf("support runs the user file's view: %s", q)
+	}
+	if !cfg.Connections[0].ReadOnly {
+		t.Error("the user file turned off read_only for a restricted role")
+	}
+	if err := Save(filepath.Join(dir, "admin.yaml"), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := os.ReadFile(userPath); !strings.Contains(string(user), "Production") {
+		t.Errorf("saving dropped the ignored entry from the user file:\n%s", user)
+	}
+	if project, _ := os.ReadFile(filepath.Join(dir, "admin.yaml")); !strings.Contains(string(project), "read_only: true") {
+		t.Errorf("saving changed the shared entry:\n%s", project)
+	}
+	if alice, _ := load().Access("alice", ""); alice.Role != "admin" {
+		t.Fatalf("alice's role = %q", alice.Role)
+	}
+	admin := load()
+	admin.Access("alice", "")
+	if admin.Views[0].Query != "SELECT * FROM payments" || admin.Connections[0].ReadOnly {
+		t.Error("an unrestricted role should get the user file's overrides")
+	}
+
+	for _, tt := range []struct {
+		user, role string
+		ok         bool
+	}{
+		{"bob", "admin", false},    // more than users: gives
+		{"alice", "support", true}, // less
+		{"carol", "default", true},
+		{"carol", "support", true},  // fewer tables than default
+		{"carol", "staging", false}, // every view
+		{"carol", "admin", false},
+		{"carol", "nope", false},
+	} {
+		a, err := cfg.Access(tt.user, tt.role)
+		if (err == nil) != tt.ok {
+			t.Errorf("Access(%s, --role %s) = %+v, %v; want ok %v", tt.user, tt.role, a, err, tt.ok)
+		}
+	}
+	if a, err := cfg.Access("carol", ""); err != nil || a.Role != "default" {
+		t.Errorf("unlisted user: %+v, %v; want the default role", a, err)
+	}
+	noDefault := &Config{Roles: map[string]*Role{"support": {}}}
+	if _, err := noDefault.Access("carol", "support"); err == nil {
+		t.Error("--role gave a role to a user with none to compare it to")
+	}
+
+	for role, allowed := range map[string]bool{"admin": true, "support": false, "staging": false, "ops": true} {
+		a, err := cfg.Access("alice", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.ManageConnections(); (err == nil) != allowed {
+			t.Errorf("role %s: ManageConnections() = %v, want allowed %v", role, err, allowed)
+		}
+	}
+}
+
+func TestMasks(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "home", "config.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", userPath)
+
+	writeFile(t, filepath.Join(dir, "admin.yaml"), `
+connections:
+  - label: "Production"
+    driver: sqlite
+    path: prod.db
+    masks:
+      - table: users
+        column: email
+        mode: partial
+      - column: card_number
+`)
+	writeFile(t, userPath, `
+connections:
+  - label: "Production"
+    driver: sqlite
+    path: copy.db
+    masks:
+      - column: phone
+        mode: hash
+`)
+
+	cfg, err := Load(filepath.Join(dir, "admin.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mask{
+		{Table: "users", Column: "email", Mode: "partial"},
+		{Column: "card_number", Mode: "full"},
+		{Column: "phone", Mode: "hash"},
+	}
+	if got := cfg.Connections[0].Masks; !reflect.DeepEqual(got, want) {
+		t.Errorf("masks = %+v, want %+v: the user layer may add masks but not drop them", got, want)
+	}
+
+	writeFile(t, userPath, `
+connections:
+  - label: "Production"
+    driver: sqlite
+    path: prod.db
+    masks:
+      - column: email
+        mode: blur
+`)
+	if _, err := Load(filepath.Join(dir, "admin.yaml")); err == nil {
+		t.Error("unknown mask mode was accepted")
+	}
+}
+
+func TestSaveKeepsOverriddenConnections(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "home", "config.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", userPath)
+
+	projectPath := filepath.Join(dir, "admin.yaml")
+	writeFile(t, projectPath, `
+connections:
+  - label: "shared"
+    driver: sqlite
+    path: team.db
+  - label: "other"
+    driver: sqlite
+    path: other.db
+`)
+	writeFile(t, userPath, `
+connections:
+  - label: "shared"
+    driver: sqlite
+    path: mine.db
+`)
+
+	cfg, err := Load(projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(projectPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	project, _ := os.ReadFile(projectPath)
+	user, _ := os.ReadFile(userPath)
+	if !strings.Contains(string(project), "team.db") || !strings.Contains(string(project), "other.db") {
+		t.Errorf("project file lost an overridden connection:\n%s", project)
+	}
+	if strings.Contains(string(project), "mine.db") {
+		t.Errorf("user override leaked into the project file:\n%s", project)
+	}
+	if !strings.Contains(string(user), "mine.db") || strings.Contains(string(user), "team.db") || strings.Contains(string(user), "other") {
+		t.Errorf("user file should hold only its override:\n%s", user)
+	}
+
+	reloaded, err := Load(projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Connections) != 2 || reloaded.Connections[0].Path != "mine.db" {
+		t.Errorf("reloaded connections = %+v", reloaded.Connections)
+	}
+
+	// Removing the override brings back the shared entry, not nothing.
+	reloaded.RemoveConnection(0)
+	if c := reloaded.Connections[0]; c.Label != "shared" || c.Path != "team.db" {
+		t.Fatalf("after removing the override, connection = %+v", c)
+	}
+	if err := Save(projectPath, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	project, _ = os.ReadFile(projectPath)
+	user, _ = os.ReadFile(userPath)
+	if !strings.Contains(string(project), "team.db") || strings.Contains(string(user), "shared") {
+		t.Errorf("removing the override changed the wrong layer:\nproject:\n%s\nuser:\n%s", project, user)
+	}
+}
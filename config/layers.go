@@ -0,0 +1,382 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "LAZYADMIN_"
+
+// UserConfigPath returns the location of the per-user config layer,
+// ~/.config/lazyadmin/config.yaml by default. XDG_CONFIG_HOME moves the
+// base directory and LAZYADMIN_USER_CONFIG replaces the whole path; setting
+// the latter to an empty string disables the user layer.
+func UserConfigPath() string {
+	if p, ok := os.LookupEnv(envPrefix + "USER_CONFIG"); ok {
+		return ExpandHome(p)
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "lazyadmin", "config.yaml")
+}
+
+// loadLayer reads one config file, loads its includes first and then merges
+// the file's own content on top. stack holds the chain of including files
+// and is used to reject include cycles.
+func (cfg *Config) loadLayer(path string, stack []string) error {
+	abs := absPath(path)
+	for _, s := range stack {
+		if s == abs {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var layer Config
+	if err := yaml.Unmarshal(data, &layer); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, inc := range layer.Include {
+		inc = ExpandHome(inc)
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(abs), inc)
+		}
+
+		if !strings.ContainsAny(inc, "*?[") {
+			if err := cfg.loadLayer(inc, stack); err != nil {
+				return fmt.Errorf("%s: include: %w", path, err)
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(inc)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", path, inc, err)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if err := cfg.loadLayer(m, stack); err != nil {
+				return fmt.Errorf("%s: include: %w", path, err)
+			}
+		}
+	}
+
+	// Backward compatibility: If Connections is empty but Database is present, use it.
+	if len(layer.Connections) == 0 && layer.Database.Driver != "" {
+		if layer.Database.Label == "" {
+			layer.Database.Label = "Default"
+		}
+		layer.Connections = append(layer.Connections, layer.Database)
+	}
+
+	cfg.merge(abs, &layer)
+	return nil
+}
+
+// merge applies layer on top of cfg. Named connections, views, roles and
+// users replace earlier entries with the same name; everything else is
+// appended.
+func (cfg *Config) merge(source string, layer *Config) {
+	cfg.files = append(cfg.files, source)
+
+	if layer.ProjectName != "" {
+		cfg.ProjectName = layer.ProjectName
+	}
+	if layer.Default != "" {
+		cfg.Default = layer.Default
+	}
+	if layer.AuditLog != "" {
+		cfg.AuditLog = layer.AuditLog
+	}
+
+	for name, role := range layer.Roles {
+		if cfg.Roles == nil {
+			cfg.Roles = make(map[string]*Role)
+		}
+		cfg.Roles[name] = role
+	}
+	for user, role := range layer.Users {
+		if cfg.Users == nil {
+			cfg.Users = make(map[string]string)
+		}
+		cfg.Users[user] = role
+	}
+
+	if len(layer.Connections) > 0 {
+		cfg.connFiles[source] = true
+	}
+	for _, c := range layer.Connections {
+		c.Source = source
+		if i := cfg.connectionIndex(c.Label); i >= 0 {
+			old := cfg.Connections[i]
+			shadows := old.shadows
+			old.shadows = nil
+			c.shadows = append(shadows, old)
+			cfg.Connections[i] = c
+		} else {
+			cfg.Connections = append(cfg.Connections, c)
+		}
+	}
+
+	for _, v := range layer.Views {
+		if i := cfg.viewIndex(v.Title); i >= 0 {
+			cfg.Views[i] = v
+		} else {
+			cfg.Views = append(cfg.Views, v)
+		}
+	}
+}
+
+func (cfg *Config) connectionIndex(label string) int {
+	if label == "" {
+		return -1
+	}
+	for i, c := range cfg.Connections {
+		if c.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveConnection removes the connection at index i. If it overrides an
+// entry of an earlier layer, that entry takes its place instead: removing
+// a connection only ever drops it from its own layer.
+func (cfg *Config) RemoveConnection(i int) {
+	c := cfg.Connections[i]
+	if n := len(c.shadows); n > 0 {
+		restored := c.shadows[n-1]
+		restored.shadows = c.shadows[: n-1 : n-1]
+		cfg.Connections[i] = restored
+		return
+	}
+	cfg.Connections = append(cfg.Connections[:i:i], cfg.Connections[i+1:]...)
+}
+
+// Edited returns edited in place of c: it is saved to the layer c came
+// from, over the same earlier entries. Fields that came from the
+// environment are saved with their file values unless the edit changed
+// them.
+func (c DatabaseConfig) Edited(edited DatabaseConfig) DatabaseConfig {
+	edited.Source, edited.shadows, edited.ignored = c.Source, c.shadows, c.ignored
+	edited.fileValues = nil
+	if c.fileValues != nil {
+		onDisk := edited
+		for _, f := range connEnvFields {
+			if f.get(&edited) == f.get(&c) {
+				// Both hold valid values, so set can't fail.
+				_ = f.set(&onDisk, f.get(c.fileValues))
+			}
+		}
+		edited.fileValues = &onDisk
+	}
+	return edited
+}
+
+// Duplicate returns a copy of c labelled label, saved to the same layer.
+// It has c's file values: the environment overrides name c's label, not
+// the copy's.
+func (c DatabaseConfig) Duplicate(label string) DatabaseConfig {
+	dup := c.persisted()
+	if c.SSH != nil {
+		ssh := *c.SSH
+		dup.SSH = &ssh
+	}
+	dup.Masks = slices.Clone(c.Masks)
+	dup.Label = label
+	dup.fileValues, dup.shadows, dup.ignored = nil, nil, nil
+	return dup
+}
+
+// masks returns the masks of each connection, by label.
+func (cfg *Config) masks() map[string][]Mask {
+	masks := make(map[string][]Mask)
+	for _, c := range cfg.Connections {
+		if len(c.Masks) > 0 {
+			masks[c.Label] = c.Masks
+		}
+	}
+	return masks
+}
+
+// keepMasks restores masks a later layer dropped when it redefined a
+// connection. The layer can still add masks of its own.
+func (cfg *Config) keepMasks(masks map[string][]Mask) {
+	for i := range cfg.Connections {
+		c := &cfg.Connections[i]
+		kept := slices.Clone(masks[c.Label])
+		for _, mk := range c.Masks {
+			if !slices.Contains(kept, mk) {
+				kept = append(kept, mk)
+			}
+		}
+		c.Masks = kept
+	}
+}
+
+func (cfg *Config) viewIndex(title string) int {
+	if title == "" {
+		return -1
+	}
+	for i, v := range cfg.Views {
+		if v.Title == title {
+			return i
+		}
+	}
+	return -1
+}
+
+type envField struct {
+	name string
+	get  func(c *DatabaseConfig) string
+	set  func(c *DatabaseConfig, v string) error
+}
+
+var connEnvFields = []envField{
+	{"DRIVER", func(c *DatabaseConfig) string { return c.Driver }, func(c *DatabaseConfig, v string) error { c.Driver = v; return nil }},
+	{"HOST", func(c *DatabaseConfig) string { return c.Host }, func(c *DatabaseConfig, v string) error { c.Host = v; return nil }},
+	{"PORT", func(c *DatabaseConfig) string { return strconv.Itoa(c.Port) }, func(c *DatabaseConfig, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid port number: %s", v)
+		}
+		c.Port = port
+		return nil
+	}},
+	{"USER", func(c *DatabaseConfig) string { return c.User }, func(c *DatabaseConfig, v string) error { c.User = v; return nil }},
+	{"PASSWORD", func(c *DatabaseConfig) string { return c.Password }, func(c *DatabaseConfig, v string) error { c.Password = v; return nil }},
+	{"NAME", func(c *DatabaseConfig) string { return c.Name }, func(c *DatabaseConfig, v string) error { c.Name = v; return nil }},
+	{"SSL_MODE", func(c *DatabaseConfig) string { return c.SSLMode }, func(c *DatabaseConfig, v string) error { c.SSLMode = v; return nil }},
+	{"PATH", func(c *DatabaseConfig) string { return c.Path }, func(c *DatabaseConfig, v string) error { c.Path = v; return nil }},
+	{"SOCKET", func(c *DatabaseConfig) string { return c.Socket }, func(c *DatabaseConfig, v string) error { c.Socket = v; return nil }},
+}
+
+// applyEnv applies the environment layer. LAZYADMIN_PROJECT_NAME,
+// LAZYADMIN_DEFAULT and LAZYADMIN_AUDIT_LOG override the project name,
+// default connection and audit log path, and
+// LAZYADMIN_CONN_<LABEL>_<FIELD> overrides a field of the connection with
+// that label, where LABEL is upper-cased with every character other than a
+// letter or digit replaced by an underscore.
+func (cfg *Config) applyEnv() error {
+	if v, ok := os.LookupEnv(envPrefix + "PROJECT_NAME"); ok {
+		cfg.ProjectName = v
+	}
+	if v, ok := os.LookupEnv(envPrefix + "DEFAULT"); ok {
+		cfg.Default = v
+	}
+	if v, ok := os.LookupEnv(envPrefix + "AUDIT_LOG"); ok {
+		cfg.AuditLog = v
+	}
+
+	for i := range cfg.Connections {
+		if err := cfg.Connections[i].applyEnv(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv applies the LAZYADMIN_CONN_<LABEL>_<FIELD> overrides of c.
+func (c *DatabaseConfig) applyEnv() error {
+	if c.Label == "" {
+		return nil
+	}
+
+	prefix := envPrefix + "CONN_" + envKey(c.Label) + "_"
+	onDisk := *c
+	overridden := false
+	for _, f := range connEnvFields {
+		v, ok := os.LookupEnv(prefix + f.name)
+		if !ok {
+			continue
+		}
+		if err := f.set(c, v); err != nil {
+			return fmt.Errorf("%s%s: %w", prefix, f.name, err)
+		}
+		overridden = true
+	}
+	if overridden {
+		c.fileValues = &onDisk
+	}
+	return nil
+}
+
+func envKey(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, label)
+}
+
+// persisted returns the connection as it should be written to disk.
+func (c DatabaseConfig) persisted() DatabaseConfig {
+	if c.fileValues != nil {
+		return *c.fileValues
+	}
+	return c
+}
+
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingValue(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// ExpandHome replaces a leading ~ in path with the user's home directory.
+func ExpandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func sameFile(a, b string) bool {
+	return absPath(a) == absPath(b)
+}
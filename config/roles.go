@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Actions a role can be allowed. Read covers browsing tables; SQL covers
+// views that change data; Reveal covers showing masked columns unmasked;
+// Manage covers adding, editing, copying, moving and deleting connections.
+const (
+	ActionRead   = "read"
+	ActionInsert = "insert"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionSQL    = "sql"
+	ActionReveal = "reveal"
+	ActionManage = "manage"
+)
+
+var actions = []string{ActionRead, ActionInsert, ActionUpdate, ActionDelete, ActionSQL, ActionReveal, ActionManage}
+
+// ErrDenied is returned for anything the current role doesn't allow.
+var ErrDenied = errors.New("not allowed")
+
+// Role limits the connections, views, tables and actions available to the
+// users given it. A list that is left out allows everything, as does "*"
+// in it; an empty list allows nothing.
+type Role struct {
+	Connections []string `yaml:"connections,omitempty"`
+	Views       []string `yaml:"views,omitempty"`
+	Tables      []string `yaml:"tables,omitempty"`
+	Actions     []string `yaml:"actions,omitempty"`
+}
+
+// Access is what the user running lazyadmin may do. The zero value, used
+// when no roles are configured, allows everything.
+type Access struct {
+	User      string // OS user
+	Requested string // role asked for with --role, if any
+	Role      string // role in effect; empty if unrestricted
+
+	rules *Role
+}
+
+// Access resolves the role of user: the one users: maps them to, or else
+// the role named "default". --role may pick another role, but only one
+// that allows no more than that; with roles configured, a user who ends up
+// with none is an error.
+//
+// A restricted role also ignores what the per-user file changes in shared
+// views and connections; see ignoreUserLayer.
+func (cfg *Config) Access(user, requested string) (Access, error) {
+	a := Access{User: user, Requested: requested}
+	if len(cfg.Roles) == 0 && len(cfg.Users) == 0 {
+		if requested != "" {
+			return a, fmt.Errorf("--role %s: no roles are configured", requested)
+		}
+		return a, nil
+	}
+
+	name, listed := cfg.Users[user]
+	if !listed {
+		if cfg.Roles["default"] == nil {
+			return a, fmt.Errorf("user %s has no role: list them under users: or define a default role", user)
+		}
+		name = "default"
+	}
+	rules := cfg.Roles[name]
+	if requested != "" && requested != name {
+		asked, ok := cfg.Roles[requested]
+		switch {
+		case !ok:
+			return a, fmt.Errorf("role %s is not defined", requested)
+		case !asked.within(rules):
+			return a, fmt.Errorf("user %s has role %s; --role can't pick %s, which allows more", user, name, requested)
+		}
+		name, rules = requested, asked
+	}
+
+	a.Role, a.rules = name, rules
+	if !rules.unrestricted() {
+		if err := cfg.ignoreUserLayer(); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+// unrestricted reports whether r limits nothing.
+func (r *Role) unrestricted() bool {
+	return r.Connections == nil && r.Views == nil && r.Tables == nil && r.Actions == nil
+}
+
+// within reports whether r allows nothing that other doesn't.
+func (r *Role) within(other *Role) bool {
+	subset := func(list, of []string) bool {
+		if of == nil || slices.Contains(of, "*") {
+			return true
+		}
+		if list == nil || slices.Contains(list, "*") {
+			return false
+		}
+		for _, name := range list {
+			if !slices.Contains(of, name) {
+				return false
+			}
+		}
+		return true
+	}
+	if other.unrestricted() {
+		return true
+	}
+	return subset(r.Connections, other.Connections) && subset(r.Views, other.Views) &&
+		subset(r.Tables, other.Tables) && subset(r.Actions, other.Actions) &&
+		(!r.manages() || other.manages())
+}
+
+// manages reports whether r may manage connections; see ManageConnections.
+func (r *Role) manages() bool {
+	return r.unrestricted() || r.Actions != nil && allows(r.Actions, ActionManage)
+}
+
+// ignoreUserLayer drops the per-user file's views and its entries for
+// shared connections, which would otherwise let a restricted user run any
+// SQL under an allowed view's title, or turn off a connection's read_only.
+// The dropped entries are still saved back to the user file.
+func (cfg *Config) ignoreUserLayer() error {
+	if cfg.userFile == "" {
+		return nil
+	}
+	cfg.Views = slices.Clone(cfg.sharedViews)
+	for i, c := range cfg.Connections {
+		n := len(c.shadows)
+		if c.Source != cfg.userFile || n == 0 {
+			continue
+		}
+		mine := c.persisted()
+		mine.shadows, mine.ignored = nil, nil
+		shared := c.shadows[n-1]
+		shared.shadows = c.shadows[: n-1 : n-1]
+		shared.ignored = &mine
+		if err := shared.applyEnv(); err != nil {
+			return err
+		}
+		if err := shared.normalize(i); err != nil {
+			return err
+		}
+		cfg.Connections[i] = shared
+	}
+	return nil
+}
+
+// Connection checks that the connection with label may be opened.
+func (a Access) Connection(label string) error {
+	return a.check(a.rules != nil && !allows(a.rules.Connections, label), "open connection %s", label)
+}
+
+// View checks that the view with title may be run.
+func (a Access) View(title string) error {
+	return a.check(a.rules != nil && !allows(a.rules.Views, title), "run view %s", title)
+}
+
+// ManageConnections checks that connections may be changed. Unlike other
+// actions, a role that restricts anything has to list it (or "*") in its
+// actions: otherwise it could relabel or rewrite the connections it may
+// not open.
+func (a Access) ManageConnections() error {
+	return a.check(a.rules != nil && !a.rules.manages(), "manage connections")
+}
+
+// Action checks that action may be done on table. Table is empty for
+// actions that aren't on a table, such as running SQL.
+func (a Access) Action(action, table string) error {
+	if a.rules == nil {
+		return nil
+	}
+	if !allows(a.rules.Actions, action) {
+		return a.check(true, "%s", action)
+	}
+	return a.check(table != "" && !allows(a.rules.Tables, table), "%s on table %s", action, table)
+}
+
+func (a Access) check(denied bool, format string, args ...interface{}) error {
+	if !denied {
+		return nil
+	}
+	return fmt.Errorf("role %s may not %s: %w", a.Role, fmt.Sprintf(format, args...), ErrDenied)
+}
+
+func allows(list []string, name string) bool {
+	return list == nil || slices.Contains(list, "*") || slices.Contains(list, name)
+}
+
+// validateRoles checks that every user has a defined role and every role
+// names known actions.
+func (cfg *Config) validateRoles() error {
+	for user, role := range cfg.Users {
+		if _, ok := cfg.Roles[role]; !ok {
+			return fmt.Errorf("user %s: role %s is not defined", user, role)
+		}
+	}
+	for name, role := range cfg.Roles {
+		if role == nil {
+			return fmt.Errorf("role %s: no rules; use {} for a role that allows everything", name)
+		}
+		for _, action := range role.Actions {
+			if action != "*" && !slices.Contains(actions, action) {
+				return fmt.Errorf("role %s: unknown action %q (want one of %v)", name, action, actions)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is returned when undoing a change to a row that has changed
+// again since.
+var ErrConflict = errors.New("row has changed since")
+
+// StaleError is returned by UpdateRecordIfUnchanged when the row no longer
+// holds the values the update expected.
+type StaleError struct {
+	Expected map[string]interface{}
+	Current  map[string]interface{} // nil if the row was deleted
+}
+
+func (e *StaleError) Error() string {
+	if e.Current == nil {
+		return "row was deleted by someone else"
+	}
+	return "row was changed by someone else since it was read"
+}
+
+func (e *StaleError) Unwrap() error { return ErrConflict }
+
+// Change is a row modification made by InsertRecord, UpdateRecord or
+// DeleteRecord, with enough of the row to reverse it.
+type Change struct {
+	Kind     string // INSERT, UPDATE or DELETE
+	Table    string
+	PKColumn string
+	PKValue  interface{}            // the row's key after the change
+	Before   map[string]interface{} // nil for INSERT
+	After    map[string]interface{} // nil for DELETE
+}
+
+// Undoable reports whether enough of the row was captured to reverse c.
+func (c Change) Undoable() bool {
+	switch c.Kind {
+	case "INSERT":
+		return c.PKColumn != "" && c.PKValue != nil
+	case "UPDATE":
+		return c.Before != nil && c.After != nil
+	case "DELETE":
+		return c.Before != nil
+	}
+	return false
+}
+
+// Undo reverses c on db and returns the statement it ran. If the row no
+// longer looks the way c left it, Undo changes nothing and returns an
+// error wrapping ErrConflict.
+func (c Change) Undo(db Querier, driver string) (Statement, error) {
+	stmt, err := c.UndoStatement(driver)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	key := c.key()
+	current, err := GetRecordByPK(db, driver, c.Table, c.PKColumn, key)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return Statement{}, err
+	}
+	switch c.Kind {
+	case "INSERT", "UPDATE":
+		if current == nil {
+			return Statement{}, fmt.Errorf("%s %s = %v: %w: it was deleted", c.Table, c.PKColumn, key, ErrConflict)
+		}
+		if c.After != nil && !sameRow(current, c.After) {
+			return Statement{}, fmt.Errorf("%s %s = %v: %w", c.Table, c.PKColumn, key, ErrConflict)
+		}
+	case "DELETE":
+		if current != nil {
+			return Statement{}, fmt.Errorf("%s %s = %v: %w: a row with that key exists again", c.Table, c.PKColumn, key, ErrConflict)
+		}
+	}
+
+	affected, err := stmt.Exec(db)
+	if err != nil {
+		return stmt, err
+	}
+	if affected == 0 {
+		return stmt, fmt.Errorf("%s %s = %v: %w", c.Table, c.PKColumn, key, ErrConflict)
+	}
+	return stmt, nil
+}
+
+// UndoStatement builds the statement Undo runs to reverse c, without
+// checking the row first.
+func (c Change) UndoStatement(driver string) (Statement, error) {
+	if !c.Undoable() {
+		return Statement{}, fmt.Errorf("%s on %s can't be undone: the row wasn't captured", c.Kind, c.Table)
+	}
+	switch c.Kind {
+	case "INSERT":
+		return BuildDelete(driver, c.Table, c.PKColumn, c.key()), nil
+	case "UPDATE":
+		return BuildUpdate(driver, c.Table, c.PKColumn, c.key(), c.Before)
+	default:
+		return BuildInsert(driver, c.Table, c.Before)
+	}
+}
+
+// key is the key of the row c left behind, or of the row it deleted.
+func (c Change) key() interface{} {
+	if v, ok := c.After[c.PKColumn]; ok {
+		return v
+	}
+	if v, ok := c.Before[c.PKColumn]; ok && c.Kind == "DELETE" {
+		return v
+	}
+	return c.PKValue
+}
+
+// holds reports whether row has the values in expect, compared as they
+// would be displayed.
+func holds(row, expect map[string]interface{}) bool {
+	for col, v := range expect {
+		if w, ok := row[col]; !ok || toString(v) != toString(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRow reports whether a and b hold the same values.
+func sameRow(a, b map[string]interface{}) bool {
+	return len(a) == len(b) && holds(a, b)
+}
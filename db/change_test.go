@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+func TestUndoChanges(t *testing.T) {
+	conn, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	q := conn.DB
+	if _, err := q.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, role TEXT DEFAULT 'user')"); err != nil {
+		t.Fatal(err)
+	}
+
+	email := func(id interface{}) string {
+		row, err := GetRecordByPK(q, "sqlite", "users", "id", id)
+		if errors.Is(err, ErrRecordNotFound) {
+			return "<none>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return toString(row["email"])
+	}
+
+	ins, err := InsertRecord(q, "sqlite", "users", "id", map[string]interface{}{"email": "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toString(ins.PKValue) != "1" || toString(ins.After["role"]) != "user" {
+		t.Fatalf("insert change = %+v, want key 1 and the stored row", ins)
+	}
+
+	upd, err := UpdateRecord(q, "sqlite", "users", "id", "1", map[string]interface{}{"email": "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, err := DeleteRecord(q, "sqlite", "users", "id", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := del.Undo(q, "sqlite"); err != nil {
+		t.Fatalf("undo delete: %v", err)
+	}
+	if got := email(1); got != "b@example.com" {
+		t.Errorf("after undoing delete, email = %s", got)
+	}
+	if _, err := upd.Undo(q, "sqlite"); err != nil {
+		t.Fatalf("undo update: %v", err)
+	}
+	if got := email(1); got != "a@example.com" {
+		t.Errorf("after undoing update, email = %s", got)
+	}
+
+	// Someone else edits the row: undoing our insert must not delete it.
+	if _, err := q.Exec("UPDATE users SET role = 'admin' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Undo(q, "sqlite"); !errors.Is(err, ErrConflict) {
+		t.Errorf("undo insert of a changed row: err = %v, want ErrConflict", err)
+	}
+	if got := email(1); got != "a@example.com" {
+		t.Errorf("conflicting undo changed the row: email = %s", got)
+	}
+}
+
+func TestUpdateRecordIfUnchanged(t *testing.T) {
+	conn, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	q := conn.DB
+	_, err = q.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, note TEXT, updated_at TIMESTAMP);
+		INSERT INTO users VALUES (1, 'a@example.com', NULL, '2024-01-01 10:00:00')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := GetRecordByPK(q, "sqlite", "users", "id", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A timestamp read back as time.Time no longer matches the stored text;
+	// the row hasn't changed, so that mustn't count as a conflict.
+	expect := map[string]interface{}{"note": original["note"], "updated_at": original["updated_at"]}
+	if _, err := UpdateRecordIfUnchanged(q, "sqlite", "users", "id", 1, map[string]interface{}{"note": "vip"}, expect); err != nil {
+		t.Fatalf("unchanged row: %v", err)
+	}
+
+	// Someone else changes the email; our edit of it started from the old one.
+	if _, err := q.Exec("UPDATE users SET email = 'c@example.com' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = UpdateRecordIfUnchanged(q, "sqlite", "users", "id", 1,
+		map[string]interface{}{"email": "b@example.com"},
+		map[string]interface{}{"email": original["email"]})
+	var stale *StaleError
+	if !errors.As(err, &stale) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want a StaleError", err)
+	}
+	if got := toString(stale.Current["email"]); got != "c@example.com" {
+		t.Errorf("current email = %s, want theirs", got)
+	}
+	if row, _ := GetRecordByPK(q, "sqlite", "users", "id", 1); toString(row["email"]) != "c@example.com" {
+		t.Errorf("stale update overwrote the row: email = %s", toString(row["email"]))
+	}
+}
+
+func TestInsertRecordUnknownKey(t *testing.T) {
+	conn, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	q := conn.DB
+	if _, err := q.Exec("CREATE TABLE tags (code TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)))), name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	// This row has rowid 1 and the next insert rowid 2, which is this
+	// row's key: trusting the last insert id would undo by deleting it.
+	if _, err := q.Exec("INSERT INTO tags (code, name) VALUES ('2', 'other')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := InsertRecord(q, "sqlite", "tags", "code", map[string]interface{}{"name": "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.PKValue != nil || ins.Undoable() {
+		t.Fatalf("insert change = %+v, want no key for a defaulted text key", ins)
+	}
+	if _, err := ins.Undo(q, "sqlite"); err == nil {
+		t.Error("undo of an insert with an unknown key succeeded")
+	}
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM tags").Scan(&n); err != nil || n != 2 {
+		t.Errorf("tags = %d (%v), want 2", n, err)
+	}
+}
+
+func TestUndoStatement(t *testing.T) {
+	del := Change{Kind: "DELETE", Table: "users", PKColumn: "id", PKValue: "3",
+		Before: map[string]interface{}{"id": int64(3), "email": "a@example.com"}}
+	stmt, err := del.UndoStatement("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stmt.SQL, "INSERT INTO") || len(stmt.Args) != 2 {
+		t.Errorf("undo of a delete = %q %v, want an insert of the row", stmt.SQL, stmt.Args)
+	}
+
+	if _, err := (Change{Kind: "INSERT", Table: "users", PKColumn: "id"}).UndoStatement("sqlite"); err == nil {
+		t.Error("undo statement for an insert without a key: want an error")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -14,9 +15,18 @@ func BuildSelectAllQuery(driver, tableName string, limit int) string {
 	return fmt.Sprintf("SELECT * FROM %s LIMIT %d", QuoteIdentifier(driver, tableName), limit)
 }
 
-func InsertRecord(db *sql.DB, driver, tableName string, data map[string]interface{}) error {
+// Querier runs statements. *sql.DB and *sql.Tx both satisfy it, so the
+// record helpers work the same inside a transaction.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// BuildInsert builds the INSERT that adds a row with data.
+func BuildInsert(driver, tableName string, data map[string]interface{}) (Statement, error) {
 	if len(data) == 0 {
-		return fmt.Errorf("no data to insert")
+		return Statement{}, fmt.Errorf("no data to insert")
 	}
 
 	sortedKeys := make([]string, 0, len(data))
@@ -32,13 +42,7 @@ func InsertRecord(db *sql.DB, driver, tableName string, data map[string]interfac
 	for i, col := range sortedKeys {
 		columns = append(columns, QuoteIdentifier(driver, col))
 		values = append(values, data[col])
-
-		switch driver {
-		case "postgres", "postgresql":
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-		default:
-			placeholders = append(placeholders, "?")
-		}
+		placeholders = append(placeholders, placeholder(driver, i+1))
 	}
 
 	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
@@ -46,13 +50,122 @@ func InsertRecord(db *sql.DB, driver, tableName string, data map[string]interfac
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "))
 
-	_, err := db.Exec(query, values...)
-	return err
+	return Statement{SQL: query, Args: values, Columns: sortedKeys, rows: 1}, nil
+}
+
+// InsertRecord adds a row with data. If pkColumn is set, the returned
+// Change carries the new row's key, read back from the database when data
+// doesn't include it, and the row as stored. When the key can't be read
+// back reliably the Change has no key and can't be undone.
+func InsertRecord(db Querier, driver, tableName, pkColumn string, data map[string]interface{}) (Change, error) {
+	change := Change{Kind: "INSERT", Table: tableName, PKColumn: pkColumn}
+	stmt, err := BuildInsert(driver, tableName, data)
+	if err != nil {
+		return change, err
+	}
+
+	key, given := data[pkColumn]
+	switch {
+	case pkColumn == "" || given:
+		_, err = stmt.Exec(db)
+	case driver == "postgres" || driver == "postgresql":
+		err = db.QueryRow(stmt.SQL+" RETURNING "+QuoteIdentifier(driver, pkColumn), stmt.Args...).Scan(&key)
+	default:
+		var result sql.Result
+		if result, err = db.Exec(stmt.SQL, stmt.Args...); err == nil {
+			key = insertedKey(db, driver, tableName, pkColumn, result, data)
+		}
+	}
+	if err != nil || key == nil {
+		// Without a key the change can't be undone.
+		return change, err
+	}
+
+	change.PKValue = key
+	change.After, _ = GetRecordByPK(db, driver, tableName, pkColumn, key)
+	return change, nil
+}
+
+// insertedKey reads the key of the row result inserted from its last insert
+// id. That id is the key only for an auto-increment key; for any other it
+// is a rowid or 0, so it is trusted only if the row it finds holds data.
+// insertedKey returns nil when the key is unknown.
+func insertedKey(db Querier, driver, tableName, pkColumn string, result sql.Result, data map[string]interface{}) interface{} {
+	id, err := result.LastInsertId()
+	if err != nil || id == 0 {
+		return nil
+	}
+	row, err := GetRecordByPK(db, driver, tableName, pkColumn, id)
+	if err != nil || !holds(row, data) {
+		return nil
+	}
+	return id
+}
+
+// Statement is a generated SQL statement and its arguments.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+	// Columns names the column each of Args is compared with or stored
+	// in, for showing them masked.
+	Columns []string
+
+	// count selects how many rows an UPDATE or DELETE would touch; rows is
+	// how many an INSERT adds.
+	count *Statement
+	rows  int64
+}
+
+// Exec runs s on db and returns the number of rows it affected.
+func (s Statement) Exec(db Querier) (int64, error) {
+	result, err := db.Exec(s.SQL, s.Args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ErrNoEstimate is returned by EstimateRows for SQL typed by hand, which
+// can't be counted without running it.
+var ErrNoEstimate = errors.New("estimate unavailable")
+
+// EstimateRows returns how many rows s would affect if run now. It never
+// runs s itself, only the count built with it, so statements without one
+// return ErrNoEstimate.
+func (s Statement) EstimateRows(db Querier) (int64, error) {
+	switch {
+	case s.count == nil && s.rows > 0:
+		return s.rows, nil
+	case s.count == nil:
+		return 0, ErrNoEstimate
+	}
+	var n int64
+	err := db.QueryRow(s.count.SQL, s.count.Args...).Scan(&n)
+	return n, err
 }
 
-func UpdateRecord(db *sql.DB, driver, tableName, pkColumn string, pkValue interface{}, data map[string]interface{}) error {
+// placeholder returns the driver's bind parameter for the n-th argument,
+// counting from 1.
+func placeholder(driver string, n int) string {
+	switch driver {
+	case "postgres", "postgresql":
+		return fmt.Sprintf("$%d", n)
+	default:
+		return "?"
+	}
+}
+
+// BuildUpdate builds the UPDATE that sets data on the row with pkValue.
+func BuildUpdate(driver, tableName, pkColumn string, pkValue interface{}, data map[string]interface{}) (Statement, error) {
+	return BuildCheckedUpdate(driver, tableName, pkColumn, pkValue, data, nil)
+}
+
+// BuildCheckedUpdate is BuildUpdate for a row that must still hold the
+// expected values: each column in expect is matched in the WHERE clause, so
+// the update touches nothing if someone else has changed them.
+func BuildCheckedUpdate(driver, tableName, pkColumn string, pkValue interface{}, data, expect map[string]interface{}) (Statement, error) {
 	if len(data) == 0 {
-		return fmt.Errorf("no data to update")
+		return Statement{}, fmt.Errorf("no data to update")
 	}
 
 	sortedKeys := make([]string, 0, len(data))
@@ -65,81 +178,155 @@ func UpdateRecord(db *sql.DB, driver, tableName, pkColumn string, pkValue interf
 	values := make([]interface{}, 0, len(data)+1)
 
 	for i, col := range sortedKeys {
-		var placeholder string
-		switch driver {
-		case "postgres", "postgresql":
-			placeholder = fmt.Sprintf("$%d", i+1)
-		default:
-			placeholder = "?"
-		}
-		setClauses = append(setClauses, fmt.Sprintf("%s = %s", QuoteIdentifier(driver, col), placeholder))
+		setClauses = append(setClauses, fmt.Sprintf("%s = %s", QuoteIdentifier(driver, col), placeholder(driver, i+1)))
 		values = append(values, data[col])
 	}
 
-	var pkPlaceholder string
-	switch driver {
-	case "postgres", "postgresql":
-		pkPlaceholder = fmt.Sprintf("$%d", len(data)+1)
-	default:
-		pkPlaceholder = "?"
-	}
-	values = append(values, pkValue)
-
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s",
+	where, whereArgs, whereCols := rowMatch(driver, pkColumn, pkValue, expect, len(values))
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
 		QuoteIdentifier(driver, tableName),
 		strings.Join(setClauses, ", "),
-		QuoteIdentifier(driver, pkColumn),
-		pkPlaceholder)
+		where)
+	values = append(values, whereArgs...)
+
+	return Statement{
+		SQL:     query,
+		Args:    values,
+		Columns: append(sortedKeys, whereCols...),
+		count:   countRows(driver, tableName, pkColumn, pkValue, expect),
+	}, nil
+}
 
-	result, err := db.Exec(query, values...)
+// countRows builds the statement that counts the rows rowMatch matches.
+func countRows(driver, tableName, pkColumn string, pkValue interface{}, expect map[string]interface{}) *Statement {
+	where, args, cols := rowMatch(driver, pkColumn, pkValue, expect, 0)
+	return &Statement{
+		SQL:     fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", QuoteIdentifier(driver, tableName), where),
+		Args:    args,
+		Columns: cols,
+	}
+}
+
+// rowMatch builds the condition matching the row with pkValue whose columns
+// hold the values in expect, its arguments and the columns they are for.
+// Its placeholders are numbered after the first offset arguments of the
+// statement.
+func rowMatch(driver, pkColumn string, pkValue interface{}, expect map[string]interface{}, offset int) (string, []interface{}, []string) {
+	conds := []string{fmt.Sprintf("%s = %s", QuoteIdentifier(driver, pkColumn), placeholder(driver, offset+1))}
+	args := []interface{}{pkValue}
+	argCols := []string{pkColumn}
+
+	cols := make([]string, 0, len(expect))
+	for col := range expect {
+		if col != pkColumn {
+			cols = append(cols, col)
+		}
+	}
+	sort.Strings(cols)
+
+	for _, col := range cols {
+		if expect[col] == nil {
+			conds = append(conds, QuoteIdentifier(driver, col)+" IS NULL")
+			continue
+		}
+		args = append(args, expect[col])
+		argCols = append(argCols, col)
+		conds = append(conds, fmt.Sprintf("%s = %s", QuoteIdentifier(driver, col), placeholder(driver, offset+len(args))))
+	}
+	return strings.Join(conds, " AND "), args, argCols
+}
+
+// UpdateRecord sets data on the row with pkValue. The returned Change holds
+// the row as it was before and after.
+func UpdateRecord(db Querier, driver, tableName, pkColumn string, pkValue interface{}, data map[string]interface{}) (Change, error) {
+	return UpdateRecordIfUnchanged(db, driver, tableName, pkColumn, pkValue, data, nil)
+}
+
+// UpdateRecordIfUnchanged is UpdateRecord for a row that must still hold
+// the values in expect, typically the ones the user started editing from.
+// If it doesn't, nothing is updated and the error is a *StaleError.
+func UpdateRecordIfUnchanged(db Querier, driver, tableName, pkColumn string, pkValue interface{}, data, expect map[string]interface{}) (Change, error) {
+	change := Change{Kind: "UPDATE", Table: tableName, PKColumn: pkColumn, PKValue: pkValue}
+	stmt, err := BuildCheckedUpdate(driver, tableName, pkColumn, pkValue, data, expect)
 	if err != nil {
-		return err
+		return change, err
 	}
 
-	affected, err := result.RowsAffected()
+	change.Before, _ = GetRecordByPK(db, driver, tableName, pkColumn, pkValue)
+
+	affected, err := stmt.Exec(db)
 	if err != nil {
-		return err
+		return change, err
 	}
 
-	if affected == 0 {
-		return fmt.Errorf("no rows updated")
+	if affected == 0 && len(expect) > 0 {
+		current, err := GetRecordByPK(db, driver, tableName, pkColumn, pkValue)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return change, err
+		}
+		if current == nil || !holds(current, expect) {
+			return change, &StaleError{Expected: expect, Current: current}
+		}
+		// The row reads the same but didn't match: a value such as a
+		// timestamp doesn't compare equal to itself once sent back to the
+		// driver. Fall back to the key alone.
+		stmt, _ = BuildUpdate(driver, tableName, pkColumn, pkValue, data)
+		if affected, err = stmt.Exec(db); err != nil {
+			return change, err
+		}
 	}
 
-	return nil
-}
+	if affected == 0 {
+		return change, fmt.Errorf("no rows updated")
+	}
 
-func DeleteRecord(db *sql.DB, driver, tableName, pkColumn string, pkValue interface{}) error {
-	var placeholder string
-	switch driver {
-	case "postgres", "postgresql":
-		placeholder = "$1"
-	default:
-		placeholder = "?"
+	// The update may have changed the key itself.
+	if v, ok := data[pkColumn]; ok {
+		change.PKValue = v
 	}
+	change.After, _ = GetRecordByPK(db, driver, tableName, pkColumn, change.PKValue)
+	return change, nil
+}
 
+// BuildDelete builds the DELETE for the row with pkValue.
+func BuildDelete(driver, tableName, pkColumn string, pkValue interface{}) Statement {
 	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
 		QuoteIdentifier(driver, tableName),
 		QuoteIdentifier(driver, pkColumn),
-		placeholder)
+		placeholder(driver, 1))
 
-	result, err := db.Exec(query, pkValue)
-	if err != nil {
-		return err
+	return Statement{
+		SQL:     query,
+		Args:    []interface{}{pkValue},
+		Columns: []string{pkColumn},
+		count:   countRows(driver, tableName, pkColumn, pkValue, nil),
 	}
+}
+
+// DeleteRecord deletes the row with pkValue. The returned Change holds the
+// deleted row.
+func DeleteRecord(db Querier, driver, tableName, pkColumn string, pkValue interface{}) (Change, error) {
+	change := Change{Kind: "DELETE", Table: tableName, PKColumn: pkColumn, PKValue: pkValue}
+	stmt := BuildDelete(driver, tableName, pkColumn, pkValue)
 
-	affected, err := result.RowsAffected()
+	change.Before, _ = GetRecordByPK(db, driver, tableName, pkColumn, pkValue)
+
+	affected, err := stmt.Exec(db)
 	if err != nil {
-		return err
+		return change, err
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("no rows deleted")
+		return change, fmt.Errorf("no rows deleted")
 	}
 
-	return nil
+	return change, nil
 }
 
-func GetRecordByPK(db *sql.DB, driver, tableName, pkColumn string, pkValue interface{}) (map[string]interface{}, error) {
+// ErrRecordNotFound is returned by GetRecordByPK when no row has the key.
+var ErrRecordNotFound = errors.New("record not found")
+
+func GetRecordByPK(db Querier, driver, tableName, pkColumn string, pkValue interface{}) (map[string]interface{}, error) {
 	var placeholder string
 	switch driver {
 	case "postgres", "postgresql":
@@ -165,7 +352,7 @@ func GetRecordByPK(db *sql.DB, driver, tableName, pkColumn string, pkValue inter
 	}
 
 	if !rows.Next() {
-		return nil, fmt.Errorf("record not found")
+		return nil, ErrRecordNotFound
 	}
 
 	values := make([]interface{}, len(columns))
@@ -1,9 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/go-sql-driver/mysql"
@@ -18,44 +23,108 @@ type Connection struct {
 }
 
 func Connect(cfg *config.DatabaseConfig) (*Connection, error) {
+	return ConnectContext(context.Background(), cfg)
+}
+
+// ConnectContext is like Connect but abandons the SSH handshake and the
+// initial ping once ctx is done.
+func ConnectContext(ctx context.Context, cfg *config.DatabaseConfig) (*Connection, error) {
 	if err := ValidateDriver(cfg.Driver); err != nil {
 		return nil, err
 	}
 
 	var tunnel *SSHTunnel
-	var dsn string
-	var err error
-
-	host := cfg.Host
-	port := cfg.Port
+	target := cfg
 
 	if cfg.SSH != nil {
-		tunnel, err = NewSSHTunnel(cfg.SSH, cfg.Host, cfg.Port)
+		network, addr := remoteEndpoint(cfg)
+		var err error
+		tunnel, err = newSSHTunnel(ctx, cfg.SSH, network, addr)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create SSH tunnel: %w", err)
 		}
-		h, p, _ := net.SplitHostPort(tunnel.LocalAddr)
-		host = h
-		fmt.Sscanf(p, "%d", &port)
+		target = viaTunnel(cfg, tunnel)
+	}
+
+	db, err := openDB(ctx, target)
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
 	}
 
+	return &Connection{DB: db, Tunnel: tunnel}, nil
+}
+
+// remoteEndpoint returns where the database listens as seen from the
+// machine that dials it: its Unix socket when one is configured, otherwise
+// host:port.
+func remoteEndpoint(cfg *config.DatabaseConfig) (network, addr string) {
+	if cfg.Socket != "" {
+		return "unix", socketPath(cfg)
+	}
+	return "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+}
+
+// socketPath returns the socket file for cfg. As with libpq, a Postgres
+// socket may be given as the directory holding it, in which case the file
+// is named after the port.
+func socketPath(cfg *config.DatabaseConfig) string {
+	if isPostgres(cfg.Driver) && !strings.HasPrefix(path.Base(cfg.Socket), ".s.PGSQL.") {
+		port := cfg.Port
+		if port == 0 {
+			port = 5432
+		}
+		return path.Join(cfg.Socket, fmt.Sprintf(".s.PGSQL.%d", port))
+	}
+	return cfg.Socket
+}
+
+// viaTunnel returns a copy of cfg that reaches the database at the local
+// end of t instead.
+func viaTunnel(cfg *config.DatabaseConfig, t *SSHTunnel) *config.DatabaseConfig {
+	c := *cfg
+	h, p, _ := net.SplitHostPort(t.LocalAddr)
+	c.Host = h
+	c.Port, _ = strconv.Atoi(p)
+	c.Socket = ""
+	return &c
+}
+
+// openDB opens and pings the database described by cfg, with its pool and
+// session settings applied.
+func openDB(ctx context.Context, cfg *config.DatabaseConfig) (*sql.DB, error) {
+	var dsn string
+
 	switch cfg.Driver {
 	case "sqlite", "sqlite3", "":
-		dsn = cfg.Path
-		if dsn == "" {
-			dsn = cfg.Name
+		dsn = sqlitePath(cfg)
+		if cfg.ReadOnly {
+			dsn = sqliteReadOnlyDSN(dsn)
 		}
 	case "postgres", "postgresql":
 		sslMode := cfg.SSLMode
 		if sslMode == "" {
 			sslMode = "disable"
 		}
+		// libpq takes a socket as its directory plus the port.
+		host, port := cfg.Host, cfg.Port
+		if cfg.Socket != "" {
+			sock := socketPath(cfg)
+			host = path.Dir(sock)
+			fmt.Sscanf(path.Base(sock), ".s.PGSQL.%d", &port)
+		}
 		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 			host, port, cfg.User, cfg.Password, cfg.Name, sslMode)
 	case "mysql":
 		mysqlCfg := mysql.NewConfig()
 		mysqlCfg.Net = "tcp"
-		mysqlCfg.Addr = fmt.Sprintf("%s:%d", host, port)
+		mysqlCfg.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+		if cfg.Socket != "" {
+			mysqlCfg.Net = "unix"
+			mysqlCfg.Addr = cfg.Socket
+		}
 		mysqlCfg.User = cfg.User
 		mysqlCfg.Passwd = cfg.Password
 		mysqlCfg.DBName = cfg.Name
@@ -64,44 +133,93 @@ func Connect(cfg *config.DatabaseConfig) (*Connection, error) {
 		return nil, fmt.Errorf("unsupported driver: %s", cfg.Driver)
 	}
 
-	driver := cfg.Driver
-	if driver == "" || driver == "sqlite" {
-		driver = "sqlite3"
-	}
-	if driver == "postgresql" {
-		driver = "postgres"
+	setup, err := sessionStatements(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		if tunnel != nil {
-			tunnel.Close()
+	var db *sql.DB
+	if len(setup) > 0 {
+		connector, err := newSessionConnector(driverName(cfg.Driver), dsn, setup)
+		if err != nil {
+			return nil, err
 		}
+		db = sql.OpenDB(connector)
+	} else if db, err = sql.Open(driverName(cfg.Driver), dsn); err != nil {
 		return nil, err
 	}
+	configurePool(db, cfg.Pool)
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		db.Close()
-		if tunnel != nil {
-			tunnel.Close()
-		}
 		return nil, err
 	}
 
-	return &Connection{DB: db, Tunnel: tunnel}, nil
+	return db, nil
+}
+
+func sqlitePath(cfg *config.DatabaseConfig) string {
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+	return cfg.Name
+}
+
+// driverName maps a configured driver to the name registered with database/sql.
+func driverName(driver string) string {
+	switch driver {
+	case "", "sqlite":
+		return "sqlite3"
+	case "postgresql":
+		return "postgres"
+	}
+	return driver
 }
 
+// Close closes the connection pool and then the SSH tunnel beneath it, so
+// that no pooled connection outlives the tunnel it runs over.
 func (c *Connection) Close() error {
+	var errs []error
+	if c.DB != nil {
+		errs = append(errs, c.DB.Close())
+	}
+	if c.Tunnel != nil {
+		errs = append(errs, c.Tunnel.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ConnectionStats describes the live resources held by a Connection.
+type ConnectionStats struct {
+	Pool sql.DBStats
+
+	// Tunnel fields are empty when the connection is direct.
+	TunnelLocalAddr  string
+	TunnelRemoteAddr string
+	TunnelServerAddr string
+	TunnelJumpAddrs  []string
+	TunnelState      TunnelState
+	TunnelErr        error // last tunnel error, if any
+	TunnelTraffic    TunnelStats
+}
+
+func (c *Connection) Stats() ConnectionStats {
+	var stats ConnectionStats
 	if c.DB != nil {
-		c.DB.Close()
+		stats.Pool = c.DB.Stats()
 	}
 	if c.Tunnel != nil {
-		c.Tunnel.Close()
+		stats.TunnelLocalAddr = c.Tunnel.LocalAddr
+		stats.TunnelRemoteAddr = c.Tunnel.RemoteAddr
+		stats.TunnelServerAddr = c.Tunnel.ServerAddr
+		stats.TunnelJumpAddrs = c.Tunnel.JumpAddrs
+		stats.TunnelState, stats.TunnelErr = c.Tunnel.State()
+		stats.TunnelTraffic = c.Tunnel.Stats()
 	}
-	return nil
+	return stats
 }
 
-func RunQuery(db *sql.DB, query string) ([]table.Column, []table.Row, error) {
+func RunQuery(db Querier, query string) ([]table.Column, []table.Row, error) {
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/qyinm/lazyadmin/config"
+)
+
+type DiagnosticStatus int
+
+const (
+	DiagnosticPass DiagnosticStatus = iota
+	DiagnosticWarn
+	DiagnosticFail
+	DiagnosticSkip
+)
+
+// DiagnosticStep is the outcome of one stage of a connection test.
+type DiagnosticStep struct {
+	Name   string
+	Status DiagnosticStatus
+	Detail string
+}
+
+type diagnosis struct {
+	stages []string
+	steps  []DiagnosticStep
+}
+
+func (d *diagnosis) add(name string, status DiagnosticStatus, format string, args ...interface{}) {
+	d.steps = append(d.steps, DiagnosticStep{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// failed records a failing step and skips every stage after it.
+func (d *diagnosis) failed(name string, err error) []DiagnosticStep {
+	d.add(name, DiagnosticFail, "%v", err)
+	for i, stage := range d.stages {
+		if stage != name {
+			continue
+		}
+		for _, r := range d.stages[i+1:] {
+			d.add(r, DiagnosticSkip, "skipped")
+		}
+		break
+	}
+	return d.steps
+}
+
+// Diagnose tries to connect with cfg one stage at a time and reports how far
+// it got: DNS resolution and TCP reachability (or the Unix socket), SSH
+// authentication, database authentication, database existence and server
+// version. It stops at the
+// first failing stage. Nothing is left open when it returns.
+func Diagnose(ctx context.Context, cfg *config.DatabaseConfig) []DiagnosticStep {
+	var d diagnosis
+	switch {
+	case isSQLite(cfg.Driver):
+		d.stages = []string{"Database file", "Database connection", "Server version"}
+	case cfg.SSH != nil:
+		d.stages = []string{"DNS resolution", "TCP reachability", "SSH authentication", "Tunnel reachability",
+			"Database authentication", "Database exists", "Server version"}
+	case cfg.Socket != "":
+		d.stages = []string{"Socket", "Database authentication", "Database exists", "Server version"}
+	default:
+		d.stages = []string{"DNS resolution", "TCP reachability", "Database authentication", "Database exists", "Server version"}
+	}
+
+	if err := ValidateDriver(cfg.Driver); err != nil {
+		return d.failed("Driver", err)
+	}
+
+	if isSQLite(cfg.Driver) {
+		path := sqlitePath(cfg)
+		if _, err := os.Stat(path); err != nil {
+			if !os.IsNotExist(err) {
+				return d.failed("Database file", err)
+			}
+			d.add("Database file", DiagnosticWarn, "%s does not exist and will be created on connect", path)
+			return d.steps
+		}
+		d.add("Database file", DiagnosticPass, "%s", path)
+	}
+
+	host, port := cfg.Host, cfg.Port
+	// With jump hosts, DNS and TCP are checked for the first hop only; the
+	// others are only reachable through it.
+	var hops []*config.SSHConfig
+	if cfg.SSH != nil {
+		var err error
+		if hops, err = sshHops(cfg.SSH); err != nil {
+			return d.failed("SSH config", err)
+		}
+		host, port = hops[0].Host, hops[0].Port
+	}
+
+	target := cfg
+	switch {
+	case isSQLite(cfg.Driver):
+	case cfg.SSH == nil && cfg.Socket != "":
+		sock := socketPath(cfg)
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "unix", sock)
+		if err != nil {
+			return d.failed("Socket", err)
+		}
+		conn.Close()
+		d.add("Socket", DiagnosticPass, "%s", sock)
+	default:
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return d.failed("DNS resolution", err)
+		}
+		d.add("DNS resolution", DiagnosticPass, "%s → %v", host, addrs)
+
+		addr := net.JoinHostPort(host, fmt.Sprint(port))
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return d.failed("TCP reachability", err)
+		}
+		d.add("TCP reachability", DiagnosticPass, "%s", addr)
+
+		if hops == nil {
+			conn.Close()
+			break
+		}
+
+		chain, err := dialSSHChain(ctx, conn, hops)
+		if err != nil {
+			return d.failed("SSH authentication", err)
+		}
+		logins := make([]string, len(hops))
+		for i, hop := range hops {
+			logins[i] = hop.User + "@" + chain.addrs[i]
+		}
+		d.add("SSH authentication", DiagnosticPass, "%s", strings.Join(logins, " → "))
+
+		network, remoteAddr := remoteEndpoint(cfg)
+		probe, err := chain.client().Dial(network, remoteAddr)
+		if err != nil {
+			chain.Close()
+			return d.failed("Tunnel reachability", err)
+		}
+		probe.Close()
+		d.add("Tunnel reachability", DiagnosticPass, "%s via %s", remoteAddr, hops[len(hops)-1].Host)
+
+		tunnel, err := startTunnel(ctx, chain, hops, network, remoteAddr)
+		if err != nil {
+			return d.failed("Tunnel reachability", err)
+		}
+		defer tunnel.Close()
+		target = viaTunnel(cfg, tunnel)
+	}
+
+	database, err := openDB(ctx, target)
+	if err != nil {
+		switch {
+		case isSQLite(cfg.Driver):
+			return d.failed("Database connection", err)
+		case isMissingDatabase(err):
+			d.add("Database authentication", DiagnosticPass, "credentials accepted for %s", cfg.User)
+			return d.failed("Database exists", err)
+		default:
+			return d.failed("Database authentication", err)
+		}
+	}
+	defer database.Close()
+
+	if isSQLite(cfg.Driver) {
+		d.add("Database connection", DiagnosticPass, "opened")
+	} else {
+		d.add("Database authentication", DiagnosticPass, "credentials accepted for %s", cfg.User)
+		d.add("Database exists", DiagnosticPass, "%s", cfg.Name)
+	}
+
+	version, err := serverVersion(ctx, database, cfg.Driver)
+	if err != nil {
+		d.add("Server version", DiagnosticWarn, "%v", err)
+	} else {
+		d.add("Server version", DiagnosticPass, "%s", version)
+	}
+
+	return d.steps
+}
+
+// isMissingDatabase reports whether err means the server accepted the
+// credentials but the requested database does not exist.
+func isMissingDatabase(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "3D000"
+	}
+
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1049
+	}
+
+	return false
+}
+
+func serverVersion(ctx context.Context, database *sql.DB, driver string) (string, error) {
+	var query string
+	switch {
+	case isSQLite(driver):
+		query = "SELECT 'SQLite ' || sqlite_version()"
+	case driver == "postgres" || driver == "postgresql":
+		query = "SELECT version()"
+	case driver == "mysql":
+		query = "SELECT CONCAT('MySQL ', VERSION())"
+	default:
+		return "", fmt.Errorf("unsupported driver: %s", driver)
+	}
+
+	var version string
+	err := database.QueryRowContext(ctx, query).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// TunnelStats are a tunnel's traffic counters since it was opened.
+type TunnelStats struct {
+	BytesIn        int64 // remote to local
+	BytesOut       int64 // local to remote
+	ActiveChannels int64
+	TotalChannels  int64
+	DialFailures   int64 // local connections the SSH server couldn't forward
+	Reconnects     int64
+}
+
+type tunnelCounters struct {
+	bytesIn, bytesOut             atomic.Int64
+	activeChannels, totalChannels atomic.Int64
+	dialFailures, reconnects      atomic.Int64
+}
+
+// Stats returns the tunnel's traffic counters.
+func (t *SSHTunnel) Stats() TunnelStats {
+	return TunnelStats{
+		BytesIn:        t.counters.bytesIn.Load(),
+		BytesOut:       t.counters.bytesOut.Load(),
+		ActiveChannels: t.counters.activeChannels.Load(),
+		TotalChannels:  t.counters.totalChannels.Load(),
+		DialFailures:   t.counters.dialFailures.Load(),
+		Reconnects:     t.counters.reconnects.Load(),
+	}
+}
+
+// forward copies between a local connection and an SSH channel until both
+// directions are done. When one side finishes sending, only the write half
+// of the other side is closed, so a peer can still send its reply after
+// its request has ended; any error tears down both directions.
+func (t *SSHTunnel) forward(local, remote net.Conn) {
+	if !t.trackForward(local) {
+		local.Close()
+		remote.Close()
+		return
+	}
+	defer t.untrackForward(local)
+
+	t.counters.totalChannels.Add(1)
+	t.counters.activeChannels.Add(1)
+	defer t.counters.activeChannels.Add(-1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn, n *atomic.Int64) {
+		defer wg.Done()
+		_, err := io.Copy(&countingWriter{w: dst, n: n}, src)
+		if err == nil {
+			if err = closeWrite(dst); err == nil {
+				return
+			}
+		}
+		// Unblock the other direction too.
+		local.Close()
+		remote.Close()
+	}
+	go pipe(remote, local, &t.counters.bytesOut)
+	go pipe(local, remote, &t.counters.bytesIn)
+	wg.Wait()
+
+	local.Close()
+	remote.Close()
+}
+
+// trackForward registers local so Close and reconnects can cut it off. It
+// returns false if the tunnel is already closed.
+func (t *SSHTunnel) trackForward(local net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == TunnelClosed {
+		return false
+	}
+	t.forwards[local] = struct{}{}
+	return true
+}
+
+func (t *SSHTunnel) untrackForward(local net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.forwards, local)
+}
+
+// closeForwards closes the local end of every active forward.
+func (t *SSHTunnel) closeForwards() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.forwards {
+		conn.Close()
+	}
+}
+
+// closeWrite half-closes c if it supports it (TCP connections and SSH
+// channels both do), and fully closes it otherwise.
+func closeWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// countingWriter adds the bytes written through it to n as they go, so
+// stats are live while a transfer is in progress.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qyinm/lazyadmin/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// UnknownHostKeyError is returned when an SSH server presents a key that is
+// not in known_hosts. The caller may show the fingerprint to the user and
+// call TrustHostKey to accept it.
+type UnknownHostKeyError struct {
+	Host           string // host:port as dialed
+	Key            ssh.PublicKey
+	KnownHostsPath string
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("unknown host key for %s (%s %s)", e.Host, e.Key.Type(), e.Fingerprint())
+}
+
+func (e *UnknownHostKeyError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+// TrustHostKey appends the key from e to its known_hosts file, creating
+// the file if needed.
+func TrustHostKey(e *UnknownHostKeyError) error {
+	if err := os.MkdirAll(filepath.Dir(e.KnownHostsPath), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(e.KnownHostsPath), err)
+	}
+
+	f, err := os.OpenFile(e.KnownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(e.Host)}, e.Key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	return nil
+}
+
+func knownHostsPath(cfg *config.SSHConfig) string {
+	if cfg.KnownHosts != "" {
+		return config.ExpandHome(cfg.KnownHosts)
+	}
+	return config.ExpandHome("~/.ssh/known_hosts")
+}
+
+// hostKeyCallback verifies the server key against the pinned host_key when
+// one is configured, and against known_hosts otherwise. It also returns the
+// host key algorithms already known for addr, so the server is asked for a
+// key type we can actually verify.
+func hostKeyCallback(cfg *config.SSHConfig, addr string) (ssh.HostKeyCallback, []string, error) {
+	if cfg.HostKey != "" {
+		cb, err := pinnedHostKey(cfg.HostKey)
+		return cb, nil, err
+	}
+
+	path := knownHostsPath(cfg)
+	var files []string
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	known, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+	}
+
+	cb := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return &UnknownHostKeyError{Host: hostname, Key: key, KnownHostsPath: path}
+			}
+			return fmt.Errorf("host key for %s does not match %s:%d (got %s %s); "+
+				"the server key changed or someone is intercepting the connection",
+				hostname, keyErr.Want[0].Filename, keyErr.Want[0].Line, key.Type(), ssh.FingerprintSHA256(key))
+		}
+		return err
+	}
+
+	return cb, knownAlgorithms(known, addr), nil
+}
+
+// knownAlgorithms probes the known_hosts callback with a throwaway key to
+// learn which key types are recorded for addr.
+func knownAlgorithms(known ssh.HostKeyCallback, addr string) []string {
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	// Resolving is not needed: knownhosts only matches on the address.
+	tcpAddr := &net.TCPAddr{IP: net.IPv4zero}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(known(addr, tcpAddr, probe), &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	seen := make(map[string]bool)
+	for _, k := range keyErr.Want {
+		for _, algo := range keyAlgorithms(k.Key.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algos = append(algos, algo)
+			}
+		}
+	}
+	return algos
+}
+
+// keyAlgorithms maps a key type to the signature algorithms that may
+// present it.
+func keyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+func pinnedHostKey(pin string) (ssh.HostKeyCallback, error) {
+	pin = strings.TrimSpace(pin)
+	if strings.HasPrefix(pin, "SHA256:") {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != pin {
+				return fmt.Errorf("host key for %s is %s, expected pinned %s", hostname, got, pin)
+			}
+			return nil
+		}, nil
+	}
+
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pin))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host_key: %w", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), want.Marshal()) {
+			return fmt.Errorf("host key for %s is %s, expected pinned %s",
+				hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(want))
+		}
+		return nil
+	}, nil
+}
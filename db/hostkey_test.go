@@ -0,0 +1,122 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsConfig returns a client config for s checked against a
+// known_hosts file in a temporary directory instead of a pinned key.
+func knownHostsConfig(t *testing.T, s *testSSHServer) config.SSHConfig {
+	t.Helper()
+	cfg := s.sshConfig(t, "alice")
+	cfg.Password = "secret"
+	cfg.HostKey = ""
+	cfg.KnownHosts = filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	return cfg
+}
+
+func otherHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestUnknownHostKeyCanBeTrusted(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t, passwordServer("secret"))
+	echoHost, echoPort := startEchoServer(t)
+	cfg := knownHostsConfig(t, server)
+
+	_, err := NewSSHTunnel(&cfg, echoHost, echoPort)
+	var unknown *UnknownHostKeyError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("connecting to an unknown host: err = %v, want an UnknownHostKeyError", err)
+	}
+	if want := ssh.FingerprintSHA256(server.signer.PublicKey()); unknown.Fingerprint() != want {
+		t.Errorf("fingerprint = %s, want %s", unknown.Fingerprint(), want)
+	}
+	if unknown.KnownHostsPath != cfg.KnownHosts {
+		t.Errorf("known_hosts path = %s, want %s", unknown.KnownHostsPath, cfg.KnownHosts)
+	}
+
+	if err := TrustHostKey(unknown); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cfg.KnownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), knownhosts.Normalize(server.addr)) {
+		t.Errorf("known_hosts = %q, want an entry for %s", data, server.addr)
+	}
+
+	tunnel, err := NewSSHTunnel(&cfg, echoHost, echoPort)
+	if err != nil {
+		t.Fatalf("reconnecting after trusting the key: %v", err)
+	}
+	defer tunnel.Close()
+	assertEcho(t, tunnel.LocalAddr)
+}
+
+func TestChangedHostKeyFails(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t, passwordServer("secret"))
+	cfg := knownHostsConfig(t, server)
+
+	if err := TrustHostKey(&UnknownHostKeyError{Host: server.addr, Key: otherHostKey(t), KnownHostsPath: cfg.KnownHosts}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewSSHTunnel(&cfg, "127.0.0.1", 5432)
+	var unknown *UnknownHostKeyError
+	switch {
+	case err == nil:
+		t.Fatal("connected to a host whose key changed")
+	case errors.As(err, &unknown):
+		t.Fatalf("changed key reported as unknown, so it could be trusted: %v", err)
+	case !strings.Contains(err.Error(), "does not match"):
+		t.Errorf("error %q does not say the key changed", err)
+	}
+}
+
+func TestPinnedHostKeyMismatchFails(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t, passwordServer("secret"))
+	other := otherHostKey(t)
+
+	for name, pin := range map[string]string{
+		"fingerprint":    ssh.FingerprintSHA256(other),
+		"authorized key": string(ssh.MarshalAuthorizedKey(other)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := server.sshConfig(t, "alice")
+			cfg.Password = "secret"
+			cfg.HostKey = pin
+
+			_, err := NewSSHTunnel(&cfg, "127.0.0.1", 5432)
+			if err == nil {
+				t.Fatal("connected to a host whose key doesn't match the pin")
+			}
+			if !strings.Contains(err.Error(), "expected pinned") {
+				t.Errorf("error %q does not name the pinned key", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Keepalive and reconnection timing. Variables so tests can shorten them.
+var (
+	sshKeepaliveInterval = 15 * time.Second
+	sshKeepaliveTimeout  = 10 * time.Second
+	sshRedialTimeout     = 30 * time.Second
+	sshRedialMinBackoff  = time.Second
+	sshRedialMaxBackoff  = 30 * time.Second
+)
+
+type TunnelState int
+
+const (
+	TunnelConnected TunnelState = iota
+	TunnelReconnecting
+	TunnelClosed
+)
+
+func (s TunnelState) String() string {
+	switch s {
+	case TunnelConnected:
+		return "connected"
+	case TunnelReconnecting:
+		return "reconnecting"
+	default:
+		return "closed"
+	}
+}
+
+// TunnelEvent reports a change in a tunnel's state, or an error forwarding
+// a connection while the state stayed the same.
+type TunnelEvent struct {
+	State TunnelState
+	Err   error
+}
+
+// Events delivers state changes and forwarding errors. Only the most recent
+// events are kept if nobody is reading. The channel is closed by Close.
+func (t *SSHTunnel) Events() <-chan TunnelEvent {
+	return t.events
+}
+
+// State returns the tunnel's current state and the last error it saw.
+func (t *SSHTunnel) State() (TunnelState, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state, t.lastErr
+}
+
+func (t *SSHTunnel) setState(state TunnelState, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == TunnelClosed {
+		return
+	}
+	t.state, t.lastErr = state, err
+	t.emit(TunnelEvent{State: state, Err: err})
+}
+
+// report records an error that doesn't change the tunnel's state.
+func (t *SSHTunnel) report(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == TunnelClosed {
+		return
+	}
+	t.lastErr = err
+	t.emit(TunnelEvent{State: t.state, Err: err})
+}
+
+// emit sends ev without blocking, dropping the oldest queued event if the
+// reader has fallen behind. t.mu must be held.
+func (t *SSHTunnel) emit(ev TunnelEvent) {
+	for {
+		select {
+		case t.events <- ev:
+			return
+		default:
+		}
+		select {
+		case <-t.events:
+		default:
+		}
+	}
+}
+
+// currentClient returns the client to forward through, or nil while the
+// tunnel is reconnecting.
+func (t *SSHTunnel) currentClient() *ssh.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.chain == nil {
+		return nil
+	}
+	return t.chain.client()
+}
+
+// checkNow asks the supervisor to probe the connection without waiting for
+// the next keepalive.
+func (t *SSHTunnel) checkNow() {
+	select {
+	case t.check <- struct{}{}:
+	default:
+	}
+}
+
+// supervise keeps the tunnel's SSH connection alive: it sends keepalives,
+// and once the connection is found dead it dials the hops again with
+// exponential backoff until it succeeds or the tunnel is closed.
+func (t *SSHTunnel) supervise() {
+	defer t.wg.Done()
+	for {
+		t.mu.Lock()
+		chain := t.chain
+		t.mu.Unlock()
+		if chain == nil {
+			return
+		}
+
+		err := t.watch(chain)
+		if t.ctx.Err() != nil {
+			return
+		}
+
+		t.mu.Lock()
+		if t.chain == chain {
+			t.chain = nil
+		}
+		t.mu.Unlock()
+		chain.Close()
+		// Forwards over the old connection can't be resumed; make their
+		// clients notice now rather than on their next write.
+		t.closeForwards()
+		t.counters.reconnects.Add(1)
+		t.setState(TunnelReconnecting, fmt.Errorf("SSH connection lost: %w", err))
+
+		if !t.redial() {
+			return
+		}
+	}
+}
+
+// watch returns once chain's connection is dead, or nil when the tunnel is
+// closing.
+func (t *SSHTunnel) watch(chain *sshChain) error {
+	client := chain.client()
+
+	closed := make(chan error, 1)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		closed <- client.Wait()
+	}()
+
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return nil
+		case err := <-closed:
+			if err == nil {
+				err = errors.New("connection closed")
+			}
+			return err
+		case <-ticker.C:
+		case <-t.check:
+		}
+
+		if err := t.keepalive(client); err != nil {
+			return err
+		}
+	}
+}
+
+// keepalive sends an OpenSSH keepalive request. Servers reply to it even if
+// they don't know it, so any reply means the connection is alive.
+func (t *SSHTunnel) keepalive(client *ssh.Client) error {
+	// A request on a dead connection can block until the connection is
+	// closed, which the caller does after the timeout.
+	errc := make(chan error, 1)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		errc <- err
+	}()
+
+	timer := time.NewTimer(sshKeepaliveTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("no keepalive reply in %s", sshKeepaliveTimeout)
+	case <-t.ctx.Done():
+		return nil
+	}
+}
+
+// redial dials the hops again until it succeeds, returning false if the
+// tunnel was closed first.
+func (t *SSHTunnel) redial() bool {
+	backoff := sshRedialMinBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-t.ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(t.ctx, sshRedialTimeout)
+		chain, err := dialSSH(ctx, t.hops)
+		cancel()
+		if err != nil {
+			t.setState(TunnelReconnecting, err)
+			backoff = min(backoff*2, sshRedialMaxBackoff)
+			continue
+		}
+
+		t.mu.Lock()
+		if t.ctx.Err() != nil {
+			t.mu.Unlock()
+			chain.Close()
+			return false
+		}
+		t.chain = chain
+		t.mu.Unlock()
+		t.setState(TunnelConnected, nil)
+		return true
+	}
+}
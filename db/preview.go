@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interpolated returns s with its arguments written into the SQL as
+// literals, for showing the statement to a user. It is never sent to the
+// database; Exec binds the arguments instead.
+func (s Statement) Interpolated() string {
+	var b strings.Builder
+	rs := []rune(s.SQL)
+	next := 0
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			// Copy quoted text as is; a doubled quote reads as two
+			// adjacent quoted runs.
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				j++
+			}
+			if j == len(rs) {
+				j--
+			}
+			b.WriteString(string(rs[i : j+1]))
+			i = j
+		case r == '?' && next < len(s.Args):
+			b.WriteString(literal(s.Args[next]))
+			next++
+		case r == '$' && i+1 < len(rs) && rs[i+1] >= '0' && rs[i+1] <= '9':
+			j := i + 1
+			for j < len(rs) && rs[j] >= '0' && rs[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(string(rs[i+1 : j]))
+			if n < 1 || n > len(s.Args) {
+				b.WriteString(string(rs[i:j]))
+			} else {
+				b.WriteString(literal(s.Args[n-1]))
+			}
+			i = j - 1
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// literal writes v as an SQL literal.
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quoteLiteral(v)
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return quoteLiteral(v.Format("2006-01-02 15:04:05.999999999Z07:00"))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	}
+	return quoteLiteral(fmt.Sprint(v))
+}
+
+// DryRun runs s and undoes it, returning how many rows it would have
+// affected. On a *sql.DB it runs in a transaction that is rolled back;
+// inside a transaction it runs under a savepoint that is rolled back, so
+// the transaction's other changes are kept. Only statements IsRowChange
+// accepts are run: anything else might commit by itself.
+func DryRun(db Querier, s Statement) (int64, error) {
+	if !IsRowChange(s.SQL) {
+		return 0, fmt.Errorf("only a single INSERT, UPDATE or DELETE can be dry-run")
+	}
+	if pool, ok := db.(*sql.DB); ok {
+		tx, err := pool.Begin()
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+		return s.Exec(tx)
+	}
+
+	if _, err := db.Exec("SAVEPOINT lazyadmin_dry_run"); err != nil {
+		return 0, err
+	}
+	n, err := s.Exec(db)
+	if _, rbErr := db.Exec("ROLLBACK TO SAVEPOINT lazyadmin_dry_run"); rbErr != nil && err == nil {
+		err = rbErr
+	}
+	db.Exec("RELEASE SAVEPOINT lazyadmin_dry_run")
+	return n, err
+}
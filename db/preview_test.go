@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+func TestInterpolated(t *testing.T) {
+	stmt, err := BuildUpdate("postgres", "what?", "id", 7, map[string]interface{}{"note": "it's $1", "score": 1.5, "gone": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `UPDATE "what?" SET "gone" = NULL, "note" = 'it''s $1', "score" = 1.5 WHERE "id" = 7`
+	if got := stmt.Interpolated(); got != want {
+		t.Errorf("postgres:\n got %s\nwant %s", got, want)
+	}
+
+	stmt, err = BuildCheckedUpdate("sqlite", "users", "id", 7, map[string]interface{}{"email": "b@example.com"},
+		map[string]interface{}{"email": "a@example.com", "note": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(stmt.Columns, stmt.Args), "[email id email] [b@example.com 7 a@example.com]"; got != want {
+		t.Errorf("columns and args = %s, want %s", got, want)
+	}
+
+	stmt = BuildDelete("mysql", "users", "id", "3")
+	if got, want := stmt.Interpolated(), "DELETE FROM `users` WHERE `id` = '3'"; got != want {
+		t.Errorf("mysql:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestDryRunRollsBack(t *testing.T) {
+	conn, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY); INSERT INTO users VALUES (1), (2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(q Querier) (n int) {
+		if err := q.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	stmt := BuildDelete("sqlite", "users", "id", 1)
+	if n, err := DryRun(conn.DB, stmt); err != nil || n != 1 {
+		t.Errorf("DryRun = %d, %v; want 1 row", n, err)
+	}
+	if n := count(conn.DB); n != 2 {
+		t.Errorf("after dry run on the pool, %d rows left, want 2", n)
+	}
+
+	// Inside a transaction, only the dry run is undone.
+	tx, err := conn.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := BuildDelete("sqlite", "users", "id", 2).Exec(tx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := DryRun(tx, stmt); err != nil || n != 1 {
+		t.Errorf("DryRun in tx = %d, %v; want 1 row", n, err)
+	}
+	if n := count(tx); n != 1 {
+		t.Errorf("after dry run in a transaction, %d rows left, want 1", n)
+	}
+}
+
+func TestEstimateTypedSQL(t *testing.T) {
+	conn, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT); INSERT INTO users VALUES (1, 'a'), (2, 'b'), (3, 'b')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The estimate is shown before the user confirms, so typed SQL must not
+	// be run to get one.
+	_, err = Statement{SQL: "UPDATE users SET role = 'c' WHERE role = 'b'"}.EstimateRows(conn.DB)
+	if !errors.Is(err, ErrNoEstimate) {
+		t.Errorf("EstimateRows of typed SQL: err = %v, want ErrNoEstimate", err)
+	}
+
+	insert, err := BuildInsert("sqlite", "users", map[string]interface{}{"role": "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := insert.EstimateRows(conn.DB); err != nil || n != 1 {
+		t.Errorf("EstimateRows(INSERT) = %d, %v; want 1", n, err)
+	}
+
+	if _, err := DryRun(conn.DB, Statement{SQL: "DROP TABLE users"}); err == nil {
+		t.Error("DryRun ran DDL, which commits by itself on some databases")
+	}
+	if _, err := (Statement{SQL: "DROP TABLE users"}).EstimateRows(conn.DB); !errors.Is(err, ErrNoEstimate) {
+		t.Errorf("EstimateRows(DROP): err = %v, want ErrNoEstimate", err)
+	}
+}
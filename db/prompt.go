@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/qyinm/lazyadmin/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthChallenge is one round of keyboard-interactive authentication, such
+// as an OTP prompt from a bastion.
+type AuthChallenge struct {
+	Host        string // host:port of the SSH server asking
+	User        string
+	Name        string
+	Instruction string
+	Questions   []string
+	Echo        []bool // false means the answer should be masked
+}
+
+// Prompter answers a keyboard-interactive challenge, usually by asking the
+// user. It returns one answer per question.
+type Prompter func(ctx context.Context, c AuthChallenge) ([]string, error)
+
+type prompterKey struct{}
+
+// WithPrompter returns a context that makes SSH connections opened with it
+// answer keyboard-interactive challenges with p. Tunnels keep using p when
+// they reconnect.
+func WithPrompter(ctx context.Context, p Prompter) context.Context {
+	return context.WithValue(ctx, prompterKey{}, p)
+}
+
+func prompterFrom(ctx context.Context) Prompter {
+	p, _ := ctx.Value(prompterKey{}).(Prompter)
+	return p
+}
+
+// keyboardInteractive answers challenges for cfg at addr. A single masked
+// password question is answered with the configured password once, since
+// many servers ask for passwords this way; everything else goes to the
+// prompter in ctx.
+func keyboardInteractive(ctx context.Context, cfg *config.SSHConfig, addr string) ssh.KeyboardInteractiveChallenge {
+	prompt := prompterFrom(ctx)
+	passwordUsed := false
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			// Servers may send an informational round with nothing to answer.
+			return nil, nil
+		}
+
+		if cfg.Password != "" && !passwordUsed && len(questions) == 1 && !echos[0] &&
+			strings.Contains(strings.ToLower(questions[0]), "password") {
+			passwordUsed = true
+			return []string{cfg.Password}, nil
+		}
+
+		if prompt == nil {
+			return nil, errors.New("server requires interactive authentication")
+		}
+		return prompt(ctx, AuthChallenge{
+			Host:        addr,
+			User:        cfg.User,
+			Name:        name,
+			Instruction: instruction,
+			Questions:   questions,
+			Echo:        echos,
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrReadOnly is returned for writes attempted on a read-only connection.
+var ErrReadOnly = errors.New("connection is read-only")
+
+// Words that make a statement write however it starts; SELECT ... INTO
+// creates a table or file.
+var writeWords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "upsert": true,
+	"create": true, "drop": true, "alter": true, "truncate": true, "rename": true,
+	"grant": true, "revoke": true, "into": true,
+}
+
+// IsReadOnlyQuery reports whether query is a single statement that only
+// reads. It is a conservative check meant to fail early with a clear
+// message; a read-only connection's sessions are what actually refuse
+// writes.
+func IsReadOnlyQuery(query string) bool {
+	words, assigns, multi := scanSQL(query)
+	if multi || len(words) == 0 {
+		return false
+	}
+
+	switch words[0] {
+	case "select", "with", "values", "table", "show", "describe", "desc":
+	case "explain":
+		// EXPLAIN ANALYZE runs the statement.
+		if len(words) > 1 && (words[1] == "analyze" || words[1] == "analyse") {
+			return false
+		}
+	case "pragma":
+		// PRAGMA name = value changes a setting; PRAGMA name(arg) reads.
+		return !assigns
+	default:
+		return false
+	}
+
+	for _, w := range words[1:] {
+		if writeWords[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsRowChange reports whether query is a single INSERT, UPDATE or DELETE,
+// which can be dry-run safely: unlike DDL, it never commits by itself.
+func IsRowChange(query string) bool {
+	words, _, multi := scanSQL(query)
+	if multi || len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "insert", "update", "delete":
+		return true
+	}
+	return false
+}
+
+// scanSQL splits query into lower-cased keywords and identifiers, skipping
+// comments, string literals and quoted identifiers. assigns reports an =
+// outside of those, and multi a second statement after a semicolon.
+func scanSQL(query string) (words []string, assigns, multi bool) {
+	rs := []rune(query)
+	ended := false
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '\'' || r == '"' || r == '`':
+			// A doubled quote inside reads as two adjacent literals.
+			i++
+			for i < len(rs) && rs[i] != r {
+				i++
+			}
+		case r == ';':
+			ended = true
+		case r == '=':
+			assigns = true
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i+1 < len(rs) && (unicode.IsLetter(rs[i+1]) || unicode.IsDigit(rs[i+1]) || rs[i+1] == '_' || rs[i+1] == '$') {
+				i++
+			}
+			if ended {
+				multi = true
+			}
+			words = append(words, strings.ToLower(string(rs[start:i+1])))
+		}
+	}
+	return words, assigns, multi
+}
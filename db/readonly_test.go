@@ -0,0 +1,65 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM users", true},
+		{"  -- recent\n(SELECT id FROM users) ORDER BY id;", true},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", true},
+		{"SELECT 'insert into x' AS note", true},
+		{`SELECT "delete" FROM t`, true},
+		{"EXPLAIN SELECT 1", true},
+		{"PRAGMA table_info('users')", true},
+		{"SHOW TABLES", true},
+
+		{"", false},
+		{"UPDATE users SET admin = true", false},
+		{"delete from users", false},
+		{"/* harmless */ DROP TABLE users", false},
+		{"SELECT 1; DROP TABLE users", false},
+		{"WITH gone AS (DELETE FROM users RETURNING *) SELECT * FROM gone", false},
+		{"SELECT * INTO backup FROM users", false},
+		{"EXPLAIN ANALYZE DELETE FROM users", false},
+		{"PRAGMA query_only = OFF", false},
+		{"SET default_transaction_read_only = off", false},
+	}
+	for _, tt := range tests {
+		if got := IsReadOnlyQuery(tt.query); got != tt.want {
+			t.Errorf("IsReadOnlyQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestReadOnlySQLiteRefusesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "100% #1.db")
+	rw, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+
+	ro, err := Connect(&config.DatabaseConfig{Driver: "sqlite", Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	if _, err := ro.DB.Exec("SELECT * FROM users"); err != nil {
+		t.Errorf("read failed: %v", err)
+	}
+	if _, err := ro.DB.Exec("INSERT INTO users (id) VALUES (1)"); err == nil {
+		t.Error("insert succeeded on a read-only connection")
+	}
+}
@@ -18,7 +18,7 @@ type ColumnInfo struct {
 	Default    sql.NullString
 }
 
-func GetTables(db *sql.DB, driver string) ([]TableInfo, error) {
+func GetTables(db Querier, driver string) ([]TableInfo, error) {
 	var query string
 
 	switch {
@@ -54,11 +54,11 @@ func GetTables(db *sql.DB, driver string) ([]TableInfo, error) {
 	return tables, rows.Err()
 }
 
-func GetColumns(db *sql.DB, driver, tableName string) ([]ColumnInfo, error) {
+func GetColumns(db Querier, driver, tableName string) ([]ColumnInfo, error) {
 	return GetColumnsWithSchema(db, driver, tableName, "public")
 }
 
-func GetColumnsWithSchema(db *sql.DB, driver, tableName, schema string) ([]ColumnInfo, error) {
+func GetColumnsWithSchema(db Querier, driver, tableName, schema string) ([]ColumnInfo, error) {
 	var query string
 	var args []interface{}
 
@@ -158,7 +158,7 @@ func GetColumnsWithSchema(db *sql.DB, driver, tableName, schema string) ([]Colum
 
 var ErrNoPrimaryKey = fmt.Errorf("no primary key found")
 
-func GetPrimaryKey(db *sql.DB, driver, tableName string) (string, error) {
+func GetPrimaryKey(db Querier, driver, tableName string) (string, error) {
 	columns, err := GetColumns(db, driver, tableName)
 	if err != nil {
 		return "", err
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+// configurePool applies p to database.
+func configurePool(database *sql.DB, p *config.PoolConfig) {
+	if p == nil {
+		return
+	}
+	if p.MaxOpen > 0 {
+		database.SetMaxOpenConns(p.MaxOpen)
+	}
+	if p.MaxIdle > 0 {
+		database.SetMaxIdleConns(p.MaxIdle)
+	}
+	if p.MaxLifetime > 0 {
+		database.SetConnMaxLifetime(p.MaxLifetime)
+	}
+	if p.MaxIdleTime > 0 {
+		database.SetConnMaxIdleTime(p.MaxIdleTime)
+	}
+}
+
+var sqliteJournalModes = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+
+// sessionStatements returns the statements that set up each new session
+// for cfg, or an error if cfg asks for a setting its driver doesn't have.
+func sessionStatements(cfg *config.DatabaseConfig) ([]string, error) {
+	var stmts []string
+	if cfg.ReadOnly {
+		stmts = append(stmts, readOnlyStatement(cfg.Driver))
+	}
+
+	s := cfg.Session
+	if s == nil {
+		return stmts, nil
+	}
+
+	unsupported := func(name string) error {
+		return fmt.Errorf("session setting %s is not supported by %s", name, cfg.Driver)
+	}
+
+	switch {
+	case isPostgres(cfg.Driver):
+		if s.StatementTimeout > 0 {
+			stmts = append(stmts, fmt.Sprintf("SET statement_timeout = %d", s.StatementTimeout.Milliseconds()))
+		}
+		if s.LockTimeout > 0 {
+			stmts = append(stmts, fmt.Sprintf("SET lock_timeout = %d", s.LockTimeout.Milliseconds()))
+		}
+		if s.SearchPath != "" {
+			stmts = append(stmts, "SET search_path TO "+s.SearchPath)
+		}
+		if s.TimeZone != "" {
+			stmts = append(stmts, "SET TIME ZONE "+quoteLiteral(s.TimeZone))
+		}
+		switch {
+		case s.SQLMode != "":
+			return nil, unsupported("sql_mode")
+		case s.BusyTimeout != 0:
+			return nil, unsupported("busy_timeout")
+		case s.ForeignKeys != nil:
+			return nil, unsupported("foreign_keys")
+		case s.JournalMode != "":
+			return nil, unsupported("journal_mode")
+		}
+
+	case cfg.Driver == "mysql":
+		if s.StatementTimeout > 0 {
+			stmts = append(stmts, fmt.Sprintf("SET SESSION max_execution_time = %d", s.StatementTimeout.Milliseconds()))
+		}
+		if s.LockTimeout > 0 {
+			// innodb_lock_wait_timeout is in whole seconds, at least one.
+			secs := max(int64((s.LockTimeout+time.Second-1)/time.Second), 1)
+			stmts = append(stmts, fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", secs))
+		}
+		if s.SQLMode != "" {
+			stmts = append(stmts, "SET SESSION sql_mode = "+quoteLiteral(s.SQLMode))
+		}
+		if s.TimeZone != "" {
+			stmts = append(stmts, "SET time_zone = "+quoteLiteral(s.TimeZone))
+		}
+		switch {
+		case s.SearchPath != "":
+			return nil, unsupported("search_path")
+		case s.BusyTimeout != 0:
+			return nil, unsupported("busy_timeout")
+		case s.ForeignKeys != nil:
+			return nil, unsupported("foreign_keys")
+		case s.JournalMode != "":
+			return nil, unsupported("journal_mode")
+		}
+
+	case isSQLite(cfg.Driver):
+		if s.BusyTimeout > 0 {
+			stmts = append(stmts, fmt.Sprintf("PRAGMA busy_timeout = %d", s.BusyTimeout.Milliseconds()))
+		}
+		if s.ForeignKeys != nil {
+			if *s.ForeignKeys {
+				stmts = append(stmts, "PRAGMA foreign_keys = ON")
+			} else {
+				stmts = append(stmts, "PRAGMA foreign_keys = OFF")
+			}
+		}
+		if s.JournalMode != "" {
+			mode := strings.ToLower(s.JournalMode)
+			if !slices.Contains(sqliteJournalModes, mode) {
+				return nil, fmt.Errorf("invalid journal_mode %q (want one of %s)", s.JournalMode, strings.Join(sqliteJournalModes, ", "))
+			}
+			stmts = append(stmts, "PRAGMA journal_mode = "+mode)
+		}
+		switch {
+		case s.StatementTimeout != 0:
+			return nil, unsupported("statement_timeout")
+		case s.LockTimeout != 0:
+			return nil, unsupported("lock_timeout (use busy_timeout)")
+		case s.SearchPath != "":
+			return nil, unsupported("search_path")
+		case s.SQLMode != "":
+			return nil, unsupported("sql_mode")
+		case s.TimeZone != "":
+			return nil, unsupported("time_zone")
+		}
+	}
+
+	return append(stmts, s.InitSQL...), nil
+}
+
+// readOnlyStatement makes a session refuse writes. It runs before init_sql,
+// so configured statements can't write either.
+func readOnlyStatement(driver string) string {
+	switch {
+	case isPostgres(driver):
+		return "SET default_transaction_read_only = on"
+	case driver == "mysql":
+		return "SET SESSION TRANSACTION READ ONLY"
+	default:
+		return "PRAGMA query_only = ON"
+	}
+}
+
+// sqliteReadOnlyDSN opens the SQLite database at dsn with mode=ro, which
+// needs it as a file: URI.
+func sqliteReadOnlyDSN(dsn string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(dsn)
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&mode=ro"
+	}
+	return dsn + "?mode=ro"
+}
+
+// sessionConnector opens connections through connector and runs init on
+// each one before database/sql gets to use it.
+type sessionConnector struct {
+	connector driver.Connector
+	init      []string
+}
+
+// newSessionConnector returns a connector for dsn that sets up every new
+// session with init.
+func newSessionConnector(driverName, dsn string, init []string) (driver.Connector, error) {
+	probe, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := probe.Driver()
+	probe.Close()
+
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: drv}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return &sessionConnector{connector: connector, init: init}, nil
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range c.init {
+		if err := execConn(ctx, conn, stmt); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("session setup %q: %w", stmt, err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *sessionConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// dsnConnector is the connector database/sql would use for a driver that
+// doesn't provide its own.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// execConn runs query, which takes no arguments, on a raw driver connection.
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	if s, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = s.ExecContext(ctx, nil)
+		return err
+	}
+	return fmt.Errorf("driver cannot execute %q", query)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + EscapeSQLiteString(s) + "'"
+}
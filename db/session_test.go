@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+func TestSessionSettingsApplyToEveryConnection(t *testing.T) {
+	on := true
+	cfg := &config.DatabaseConfig{
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "test.db"),
+		Pool:   &config.PoolConfig{MaxOpen: 2},
+		Session: &config.SessionConfig{
+			BusyTimeout: 2 * time.Second,
+			ForeignKeys: &on,
+			InitSQL:     []string{"CREATE TEMP TABLE session_marker (id INTEGER)"},
+		},
+	}
+	conn, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := conn.DB.Stats().MaxOpenConnections; got != 2 {
+		t.Errorf("MaxOpenConnections = %d, want 2", got)
+	}
+
+	// Hold two sessions at once so the pool has to open a second one.
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		c, err := conn.DB.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		var fk, busy int
+		if err := c.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fk); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busy); err != nil {
+			t.Fatal(err)
+		}
+		if fk != 1 || busy != 2000 {
+			t.Errorf("session %d: foreign_keys = %d, busy_timeout = %d; want 1, 2000", i, fk, busy)
+		}
+		if _, err := c.ExecContext(ctx, "SELECT * FROM session_marker"); err != nil {
+			t.Errorf("session %d: init_sql did not run: %v", i, err)
+		}
+	}
+}
+
+func TestSessionSettingsRejectOtherDrivers(t *testing.T) {
+	cfg := &config.DatabaseConfig{
+		Driver:  "sqlite",
+		Session: &config.SessionConfig{SearchPath: "app"},
+	}
+	if _, err := sessionStatements(cfg); err == nil || !strings.Contains(err.Error(), "search_path") {
+		t.Errorf("err = %v, want search_path unsupported", err)
+	}
+
+	cfg = &config.DatabaseConfig{
+		Driver:  "mysql",
+		Session: &config.SessionConfig{LockTimeout: 1500 * time.Millisecond, SQLMode: "TRADITIONAL"},
+	}
+	stmts, err := sessionStatements(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SET SESSION innodb_lock_wait_timeout = 2", "SET SESSION sql_mode = 'TRADITIONAL'"}
+	if strings.Join(stmts, "; ") != strings.Join(want, "; ") {
+		t.Errorf("statements = %q, want %q", stmts, want)
+	}
+}
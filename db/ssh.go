@@ -1,27 +1,184 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"sync"
 
 	"github.com/qyinm/lazyadmin/config"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+// SSHTunnel forwards a local port to a remote address through SSH. A
+// background supervisor keeps the SSH connection alive and re-dials it when
+// it drops; the local port stays the same across reconnects.
 type SSHTunnel struct {
-	client   *ssh.Client
+	hops     []*config.SSHConfig
 	listener net.Listener
-	LocalAddr string
+
+	LocalAddr  string   // local end of the tunnel, 127.0.0.1:port
+	RemoteAddr string   // address (or Unix socket path) the SSH server forwards to
+	ServerAddr string   // SSH server the tunnel goes through
+	JumpAddrs  []string // jump hosts in front of ServerAddr, in dialing order
+
+	mu      sync.Mutex
+	chain   *sshChain // nil while reconnecting
+	state   TunnelState
+	lastErr error
+	events  chan TunnelEvent
+	check   chan struct{}
+
+	forwards map[net.Conn]struct{} // local ends of active forwards
+	counters tunnelCounters
+
+	ctx       context.Context // cancelled by Close
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
 }
 
 func NewSSHTunnel(cfg *config.SSHConfig, remoteHost string, remotePort int) (*SSHTunnel, error) {
+	return NewSSHTunnelContext(context.Background(), cfg, remoteHost, remotePort)
+}
+
+// NewSSHTunnelContext is like NewSSHTunnel but gives up on dialing and the
+// SSH handshake once ctx is done.
+func NewSSHTunnelContext(ctx context.Context, cfg *config.SSHConfig, remoteHost string, remotePort int) (*SSHTunnel, error) {
+	return newSSHTunnel(ctx, cfg, "tcp", net.JoinHostPort(remoteHost, fmt.Sprint(remotePort)))
+}
+
+// newSSHTunnel opens a tunnel to remoteAddr on the far side of cfg. network
+// is "tcp", or "unix" to forward to a Unix socket on the SSH server.
+func newSSHTunnel(ctx context.Context, cfg *config.SSHConfig, network, remoteAddr string) (*SSHTunnel, error) {
+	hops, err := sshHops(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := dialSSH(ctx, hops)
+	if err != nil {
+		return nil, err
+	}
+
+	return startTunnel(ctx, chain, hops, network, remoteAddr)
+}
+
+// dialSSH connects to the first hop and authenticates along the chain.
+func dialSSH(ctx context.Context, hops []*config.SSHConfig) (*sshChain, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", sshAddr(hops[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial SSH: %w", err)
+	}
+	return dialSSHChain(ctx, conn, hops)
+}
+
+// maxSSHJumpDepth bounds how deeply jump hosts may themselves use jump
+// hosts, which also catches ProxyJump loops in ~/.ssh/config.
+const maxSSHJumpDepth = 8
+
+// sshHops resolves cfg and, recursively, its jump hosts into the order they
+// are dialed: every jump host first and cfg's own server last.
+func sshHops(cfg *config.SSHConfig) ([]*config.SSHConfig, error) {
+	return appendSSHHops(nil, cfg, 0)
+}
+
+func appendSSHHops(hops []*config.SSHConfig, cfg *config.SSHConfig, depth int) ([]*config.SSHConfig, error) {
+	if depth > maxSSHJumpDepth {
+		return nil, errors.New("too many nested SSH jump hosts")
+	}
+
+	resolved, err := resolveSSHConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resolved.Jump {
+		if hops, err = appendSSHHops(hops, &resolved.Jump[i], depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return append(hops, resolved), nil
+}
+
+func sshAddr(cfg *config.SSHConfig) string {
+	return net.JoinHostPort(cfg.Host, fmt.Sprint(cfg.Port))
+}
+
+// sshChain is an SSH client reached through zero or more jump hosts.
+type sshChain struct {
+	clients []*ssh.Client // jump hosts first, the target server last
+	addrs   []string
+}
+
+// client returns the client for the last hop, the one that forwards.
+func (c *sshChain) client() *ssh.Client {
+	return c.clients[len(c.clients)-1]
+}
+
+// Close closes the clients from the last hop back to the first, so each
+// client goes away before the connection it runs over.
+func (c *sshChain) Close() error {
+	var errs []error
+	for i := len(c.clients) - 1; i >= 0; i-- {
+		errs = append(errs, c.clients[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// dialSSHChain authenticates to every hop in turn. conn must already be
+// connected to the first hop; each later hop is dialed through the client
+// of the hop before it. Everything is closed on failure.
+func dialSSHChain(ctx context.Context, conn net.Conn, hops []*config.SSHConfig) (*sshChain, error) {
+	chain := &sshChain{}
+	for i, hop := range hops {
+		addr := sshAddr(hop)
+		if i > 0 {
+			var err error
+			conn, err = chain.client().DialContext(ctx, "tcp", addr)
+			if err != nil {
+				chain.Close()
+				return nil, fmt.Errorf("failed to dial SSH %s through %s: %w", addr, chain.addrs[i-1], err)
+			}
+		}
+
+		client, err := sshHandshake(ctx, conn, addr, hop)
+		if err != nil {
+			chain.Close()
+			if i > 0 {
+				return nil, fmt.Errorf("%s (through %s): %w", addr, chain.addrs[i-1], err)
+			}
+			return nil, err
+		}
+		chain.clients = append(chain.clients, client)
+		chain.addrs = append(chain.addrs, addr)
+	}
+	return chain, nil
+}
+
+// sshClientConfig builds the client config for cfg, which must already be
+// resolved. Authentication is tried in the order ssh uses: the configured
+// key, then keys held by the SSH agent, then the password, then
+// keyboard-interactive through the Prompter in ctx. The returned cleanup
+// func releases the agent connection once the handshake is done.
+func sshClientConfig(ctx context.Context, cfg *config.SSHConfig, addr string) (*ssh.ClientConfig, func(), error) {
 	var authMethods []ssh.AuthMethod
+	cleanup := func() {}
+
+	agentSigners, agentConn := sshAgentSigners()
+	if agentConn != nil {
+		cleanup = func() { agentConn.Close() }
+	}
 
 	if cfg.PrivateKey != "" {
-		key, err := os.ReadFile(cfg.PrivateKey)
+		key, err := os.ReadFile(config.ExpandHome(cfg.PrivateKey))
 		if err != nil {
-			return nil, fmt.Errorf("failed to read private key: %w", err)
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to read private key: %w", err)
 		}
 
 		var signer ssh.Signer
@@ -30,105 +187,171 @@ func NewSSHTunnel(cfg *config.SSHConfig, remoteHost string, remotePort int) (*SS
 		} else {
 			signer, err = ssh.ParsePrivateKey(key)
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
+
+		var missingPassphrase *ssh.PassphraseMissingError
+		switch {
+		case err == nil:
+			authMethods = append(authMethods, ssh.PublicKeys(signer))
+		case errors.As(err, &missingPassphrase) && agentSigners != nil:
+			// An encrypted key is usually loaded into the agent as well.
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
 		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+
+	if agentSigners != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(agentSigners))
 	}
 
 	if cfg.Password != "" && cfg.PrivateKey == "" {
 		authMethods = append(authMethods, ssh.Password(cfg.Password))
 	}
+	authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractive(ctx, cfg, addr)))
 
-	sshConfig := &ssh.ClientConfig{
-		User:            cfg.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	hostKeyCB, hostKeyAlgos, err := hostKeyCallback(cfg, addr)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
 	}
 
-	sshAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	client, err := ssh.Dial("tcp", sshAddr, sshConfig)
+	return &ssh.ClientConfig{
+		User:              cfg.User,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCB,
+		HostKeyAlgorithms: hostKeyAlgos,
+	}, cleanup, nil
+}
+
+// sshAgentSigners connects to the agent at SSH_AUTH_SOCK. It returns nils
+// when no agent is running, so agent auth is simply not offered.
+func sshAgentSigners() (func() ([]ssh.Signer, error), net.Conn) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", sock)
 	if err != nil {
+		return nil, nil
+	}
+	return agent.NewClient(conn).Signers, conn
+}
+
+// sshHandshake authenticates over an established connection. The deadline
+// of ctx, if any, bounds the handshake; conn is closed on failure.
+func sshHandshake(ctx context.Context, conn net.Conn, addr string, cfg *config.SSHConfig) (*ssh.Client, error) {
+	sshConfig, cleanup, err := sshClientConfig(ctx, cfg, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer cleanup()
+
+	// Connections through a jump host don't support deadlines, so give up
+	// on the handshake by closing conn instead.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if !stop() {
+		err = errors.Join(ctx.Err(), err)
+	}
+	if err != nil {
+		if c != nil {
+			c.Close()
+		}
+		conn.Close()
 		return nil, fmt.Errorf("failed to dial SSH: %w", err)
 	}
 
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// startTunnel listens on a random local port and forwards every accepted
+// connection to remoteAddr through the last client of chain, over TCP or,
+// for network "unix", to a Unix socket. hops are what chain was dialed
+// from, for reconnecting; ctx supplies their Prompter.
+func startTunnel(ctx context.Context, chain *sshChain, hops []*config.SSHConfig, network, remoteAddr string) (*SSHTunnel, error) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		client.Close()
+		chain.Close()
 		return nil, fmt.Errorf("failed to create local listener: %w", err)
 	}
 
+	last := len(chain.addrs) - 1
 	tunnel := &SSHTunnel{
-		client:    client,
-		listener:  listener,
-		LocalAddr: listener.Addr().String(),
+		hops:       hops,
+		chain:      chain,
+		listener:   listener,
+		LocalAddr:  listener.Addr().String(),
+		RemoteAddr: remoteAddr,
+		ServerAddr: chain.addrs[last],
+		JumpAddrs:  chain.addrs[:last],
+		events:     make(chan TunnelEvent, 8),
+		check:      make(chan struct{}, 1),
+		forwards:   make(map[net.Conn]struct{}),
+	}
+	// Reconnects outlive the ctx the tunnel was opened with, but should
+	// prompt the same way.
+	tunnel.ctx, tunnel.cancel = context.WithCancel(context.Background())
+	if prompt := prompterFrom(ctx); prompt != nil {
+		tunnel.ctx = WithPrompter(tunnel.ctx, prompt)
 	}
 
-	remoteAddr := fmt.Sprintf("%s:%d", remoteHost, remotePort)
-
+	tunnel.wg.Add(2)
+	go tunnel.supervise()
 	go func() {
+		defer tunnel.wg.Done()
 		for {
 			localConn, err := listener.Accept()
 			if err != nil {
 				return
 			}
 
-			remoteConn, err := client.Dial("tcp", remoteAddr)
-			if err != nil {
+			client := tunnel.currentClient()
+			if client == nil {
+				// Reconnecting; fail fast rather than hang the pool.
 				localConn.Close()
 				continue
 			}
 
-			go tunnel.forward(localConn, remoteConn)
-		}
-	}()
-
-	return tunnel, nil
-}
-
-func (t *SSHTunnel) forward(local, remote net.Conn) {
-	defer local.Close()
-	defer remote.Close()
-
-	done := make(chan struct{}, 2)
-
-	go func() {
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := remote.Read(buf)
-			if n > 0 {
-				local.Write(buf[:n])
-			}
+			remoteConn, err := client.Dial(network, remoteAddr)
 			if err != nil {
-				break
+				localConn.Close()
+				tunnel.counters.dialFailures.Add(1)
+				tunnel.report(fmt.Errorf("failed to reach %s: %w", remoteAddr, err))
+				tunnel.checkNow()
+				continue
 			}
-		}
-		done <- struct{}{}
-	}()
 
-	go func() {
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := local.Read(buf)
-			if n > 0 {
-				remote.Write(buf[:n])
-			}
-			if err != nil {
-				break
-			}
+			tunnel.wg.Add(1)
+			go func() {
+				defer tunnel.wg.Done()
+				tunnel.forward(localConn, remoteConn)
+			}()
 		}
-		done <- struct{}{}
 	}()
 
-	<-done
+	return tunnel, nil
 }
 
+// Close stops accepting local connections and reconnecting, closes the SSH
+// clients (which ends every forwarded channel) and waits for all tunnel
+// goroutines to exit. It is safe to call more than once.
 func (t *SSHTunnel) Close() error {
-	if t.listener != nil {
-		t.listener.Close()
-	}
-	if t.client != nil {
-		return t.client.Close()
-	}
-	return nil
+	t.closeOnce.Do(func() {
+		t.cancel()
+		if t.listener != nil {
+			t.listener.Close()
+		}
+		t.mu.Lock()
+		if t.chain != nil {
+			t.closeErr = t.chain.Close()
+			t.chain = nil
+		}
+		t.state = TunnelClosed
+		t.mu.Unlock()
+		t.closeForwards()
+		t.wg.Wait()
+		close(t.events)
+	})
+	return t.closeErr
 }
@@ -0,0 +1,650 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qyinm/lazyadmin/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that allows direct-tcpip and
+// direct-streamlocal forwarding and records where it was asked to forward to.
+type testSSHServer struct {
+	addr   string
+	signer ssh.Signer
+
+	mu     sync.Mutex
+	dialed []string
+	open   map[net.Conn]bool
+	conns  atomic.Int32
+
+	// stalled makes the server ignore global requests such as keepalives,
+	// like a server on the far side of a dead network path.
+	stalled atomic.Bool
+}
+
+func newTestSSHServer(t *testing.T, cfg *ssh.ServerConfig) *testSSHServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &testSSHServer{addr: l.Addr().String(), signer: signer, open: map[net.Conn]bool{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.conns.Add(1)
+	defer s.conns.Add(-1)
+	s.mu.Lock()
+	s.open[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.open, conn)
+		s.mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			if req.WantReply && !s.stalled.Load() {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	for newCh := range chans {
+		network, addr, err := forwardTarget(newCh)
+		if err != nil {
+			newCh.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		if network == "" {
+			newCh.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		s.mu.Lock()
+		s.dialed = append(s.dialed, addr)
+		s.mu.Unlock()
+
+		target, err := net.Dial(network, addr)
+		if err != nil {
+			newCh.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			defer ch.Close()
+			defer target.Close()
+			go func() {
+				io.Copy(target, ch)
+				closeWrite(target)
+			}()
+			io.Copy(ch, target)
+			ch.CloseWrite()
+		}()
+	}
+	sconn.Wait()
+}
+
+// forwardTarget decodes where a forwarding channel wants to go. network is
+// empty for channel types the server doesn't forward.
+func forwardTarget(newCh ssh.NewChannel) (network, addr string, err error) {
+	switch newCh.ChannelType() {
+	case "direct-tcpip":
+		var req struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+			return "", "", err
+		}
+		return "tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))), nil
+	case "direct-streamlocal@openssh.com":
+		var req struct {
+			SocketPath string
+			Reserved0  string
+			Reserved1  uint32
+		}
+		if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+			return "", "", err
+		}
+		return "unix", req.SocketPath, nil
+	}
+	return "", "", nil
+}
+
+// dropConnections cuts every client connection, as a network failure would.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.open {
+		conn.Close()
+	}
+}
+
+func (s *testSSHServer) dialedAddrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.dialed...)
+}
+
+// sshConfig returns a client config for s with its host key pinned.
+func (s *testSSHServer) sshConfig(t *testing.T, user string) config.SSHConfig {
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return config.SSHConfig{Host: host, Port: p, User: user, HostKey: ssh.FingerprintSHA256(s.signer.PublicKey())}
+}
+
+func passwordServer(password string) *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+}
+
+// startEchoServer returns the address of a TCP server that echoes what it
+// reads until the client stops writing.
+func startEchoServer(t *testing.T) (string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// startUnixEchoServer is startEchoServer on a Unix socket.
+func startUnixEchoServer(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "echo.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return path
+}
+
+// assertEcho checks that a round trip through addr to an echo server works.
+func assertEcho(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("echo = %q, want ping", buf)
+	}
+}
+
+// shortenSSHTimers makes keepalives and reconnects fast for the test.
+func shortenSSHTimers(t *testing.T) {
+	interval, timeout, minBackoff := sshKeepaliveInterval, sshKeepaliveTimeout, sshRedialMinBackoff
+	sshKeepaliveInterval, sshKeepaliveTimeout, sshRedialMinBackoff = 20*time.Millisecond, 100*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() {
+		sshKeepaliveInterval, sshKeepaliveTimeout, sshRedialMinBackoff = interval, timeout, minBackoff
+	})
+}
+
+// waitForTunnelState reads events until one reports state.
+func waitForTunnelState(t *testing.T, tunnel *SSHTunnel, state TunnelState) TunnelEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-tunnel.Events():
+			if ev.State == state {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("tunnel never became %s", state)
+		}
+	}
+}
+
+func TestSSHTunnelThroughJumpHosts(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	// The second bastion only accepts a key file.
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bastion1 := newTestSSHServer(t, passwordServer("first"))
+	bastion2 := newTestSSHServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), userSigner.PublicKey().Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	})
+	server := newTestSSHServer(t, passwordServer("last"))
+	echoHost, echoPort := startEchoServer(t)
+
+	hop1 := bastion1.sshConfig(t, "alice")
+	hop1.Password = "first"
+	hop2 := bastion2.sshConfig(t, "bob")
+	hop2.PrivateKey = keyPath
+	cfg := server.sshConfig(t, "carol")
+	cfg.Password = "last"
+	cfg.Jump = []config.SSHConfig{hop1, hop2}
+
+	tunnel, err := NewSSHTunnel(&cfg, echoHost, echoPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(tunnel.JumpAddrs, []string{bastion1.addr, bastion2.addr}) || tunnel.ServerAddr != server.addr {
+		t.Errorf("tunnel goes through %v to %s", tunnel.JumpAddrs, tunnel.ServerAddr)
+	}
+
+	assertEcho(t, tunnel.LocalAddr)
+
+	// Each hop was reached through the one before it.
+	echoAddr := net.JoinHostPort(echoHost, strconv.Itoa(echoPort))
+	for _, tc := range []struct {
+		server *testSSHServer
+		want   string
+	}{{bastion1, bastion2.addr}, {bastion2, server.addr}, {server, echoAddr}} {
+		if got := tc.server.dialedAddrs(); !reflect.DeepEqual(got, []string{tc.want}) {
+			t.Errorf("server %s forwarded to %v, want [%s]", tc.server.addr, got, tc.want)
+		}
+	}
+
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range []*testSSHServer{bastion1, bastion2, server} {
+		for s.conns.Load() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := s.conns.Load(); n != 0 {
+			t.Errorf("server %s still has %d connections after Close", s.addr, n)
+		}
+	}
+}
+
+func TestSSHTunnelJumpHostAuthFailure(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	bastion := newTestSSHServer(t, passwordServer("right"))
+	server := newTestSSHServer(t, passwordServer("right"))
+
+	hop := bastion.sshConfig(t, "alice")
+	hop.Password = "right"
+	cfg := server.sshConfig(t, "bob")
+	cfg.Password = "wrong"
+	cfg.Jump = []config.SSHConfig{hop}
+
+	_, err := NewSSHTunnel(&cfg, "127.0.0.1", 5432)
+	if err == nil {
+		t.Fatal("expected an authentication error")
+	}
+	if !strings.Contains(err.Error(), "through "+bastion.addr) {
+		t.Errorf("error %q does not name the jump host", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bastion.conns.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := bastion.conns.Load(); n != 0 {
+		t.Errorf("jump host connection left open after failure (%d)", n)
+	}
+}
+
+func TestSSHTunnelReconnects(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	shortenSSHTimers(t)
+
+	server := newTestSSHServer(t, passwordServer("secret"))
+	echoHost, echoPort := startEchoServer(t)
+	cfg := server.sshConfig(t, "alice")
+	cfg.Password = "secret"
+
+	tunnel, err := NewSSHTunnel(&cfg, echoHost, echoPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	assertEcho(t, tunnel.LocalAddr)
+
+	// A dropped connection is noticed and re-dialed on the same local port.
+	server.dropConnections()
+	if ev := waitForTunnelState(t, tunnel, TunnelReconnecting); ev.Err == nil {
+		t.Error("reconnecting event carries no error")
+	}
+	waitForTunnelState(t, tunnel, TunnelConnected)
+	assertEcho(t, tunnel.LocalAddr)
+
+	// So is a connection that silently stops answering.
+	server.stalled.Store(true)
+	ev := waitForTunnelState(t, tunnel, TunnelReconnecting)
+	if ev.Err == nil || !strings.Contains(ev.Err.Error(), "keepalive") {
+		t.Errorf("reconnecting after stall: err = %v, want a keepalive timeout", ev.Err)
+	}
+	server.stalled.Store(false)
+	waitForTunnelState(t, tunnel, TunnelConnected)
+	assertEcho(t, tunnel.LocalAddr)
+
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if state, _ := tunnel.State(); state != TunnelClosed {
+		t.Errorf("state after Close = %s", state)
+	}
+	for range tunnel.Events() {
+	}
+}
+
+func TestSSHTunnelKeyboardInteractive(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	// A bastion asking for the password and then an OTP, in two rounds.
+	server := newTestSSHServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil || len(answers) != 1 || answers[0] != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			answers, err = client("MFA", "Enter the code from your app.", []string{"Verification code: "}, []bool{true})
+			if err != nil || len(answers) != 1 || answers[0] != "123456" {
+				return nil, errors.New("wrong code")
+			}
+			return nil, nil
+		},
+	})
+	echoHost, echoPort := startEchoServer(t)
+	cfg := server.sshConfig(t, "alice")
+	cfg.Password = "secret"
+
+	if _, err := NewSSHTunnel(&cfg, echoHost, echoPort); err == nil || !strings.Contains(err.Error(), "interactive") {
+		t.Fatalf("without a prompter: err = %v, want interactive authentication error", err)
+	}
+
+	var challenges []AuthChallenge
+	prompt := func(_ context.Context, c AuthChallenge) ([]string, error) {
+		challenges = append(challenges, c)
+		return []string{"123456"}, nil
+	}
+	tunnel, err := NewSSHTunnelContext(WithPrompter(context.Background(), prompt), &cfg, echoHost, echoPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	assertEcho(t, tunnel.LocalAddr)
+
+	// Only the OTP reached the prompter; the password was answered from cfg.
+	want := []AuthChallenge{{
+		Host: server.addr, User: "alice", Name: "MFA", Instruction: "Enter the code from your app.",
+		Questions: []string{"Verification code: "}, Echo: []bool{true},
+	}}
+	if !reflect.DeepEqual(challenges, want) {
+		t.Errorf("challenges = %+v, want %+v", challenges, want)
+	}
+}
+
+// startReplyServer returns the address of a TCP server that reads a whole
+// request until the client half-closes, then replies and closes.
+func startReplyServer(t *testing.T, reply func(request []byte) []byte) (string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, err := io.ReadAll(conn)
+				if err != nil {
+					return
+				}
+				conn.Write(reply(request))
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestSSHTunnelHalfCloseAndStats(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := newTestSSHServer(t, passwordServer("secret"))
+	host, port := startReplyServer(t, func(request []byte) []byte {
+		return []byte("received " + strconv.Itoa(len(request)) + " bytes")
+	})
+	cfg := server.sshConfig(t, "alice")
+	cfg.Password = "secret"
+
+	tunnel, err := NewSSHTunnel(&cfg, host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	conn, err := net.Dial("tcp", tunnel.LocalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := bytes.Repeat([]byte("x"), 100_000)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	// The server only replies once it sees EOF, and the reply must still
+	// make it back after our write half is closed.
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "received 100000 bytes"; string(reply) != want {
+		t.Fatalf("reply = %q, want %q", reply, want)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tunnel.Stats().ActiveChannels != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := TunnelStats{BytesIn: int64(len(reply)), BytesOut: int64(len(request)), TotalChannels: 1}
+	if got := tunnel.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestSSHTunnelCountsDialFailures(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := newTestSSHServer(t, passwordServer("secret"))
+	cfg := server.sshConfig(t, "alice")
+	cfg.Password = "secret"
+
+	// Nothing listens on the remote port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	tunnel, err := NewSSHTunnel(&cfg, "127.0.0.1", closedPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	conn, err := net.Dial("tcp", tunnel.LocalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("read through a tunnel to a closed port succeeded")
+	}
+	conn.Close()
+
+	ev := waitForTunnelState(t, tunnel, TunnelConnected)
+	if ev.Err == nil || !strings.Contains(ev.Err.Error(), "failed to reach") {
+		t.Errorf("event err = %v, want a forwarding error", ev.Err)
+	}
+	if got := tunnel.Stats(); got.DialFailures != 1 || got.TotalChannels != 0 {
+		t.Errorf("stats = %+v, want 1 dial failure and no channels", got)
+	}
+}
+
+func TestSSHTunnelToUnixSocket(t *testing.T) {
+	server := newTestSSHServer(t, passwordServer("secret"))
+	sock := startUnixEchoServer(t)
+
+	cfg := server.sshConfig(t, "alice")
+	cfg.Password = "secret"
+	tunnel, err := newSSHTunnel(context.Background(), &cfg, "unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	assertEcho(t, tunnel.LocalAddr)
+	if got := server.dialedAddrs(); len(got) != 1 || got[0] != sock {
+		t.Errorf("server dialed %v, want [%s]", got, sock)
+	}
+	if tunnel.RemoteAddr != sock {
+		t.Errorf("RemoteAddr = %q, want %q", tunnel.RemoteAddr, sock)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	tests := []struct {
+		cfg  config.DatabaseConfig
+		want string
+	}{
+		{config.DatabaseConfig{Driver: "postgres", Socket: "/var/run/postgresql"}, "/var/run/postgresql/.s.PGSQL.5432"},
+		{config.DatabaseConfig{Driver: "postgres", Socket: "/tmp", Port: 5433}, "/tmp/.s.PGSQL.5433"},
+		{config.DatabaseConfig{Driver: "postgresql", Socket: "/tmp/.s.PGSQL.6000"}, "/tmp/.s.PGSQL.6000"},
+		{config.DatabaseConfig{Driver: "mysql", Socket: "/var/run/mysqld/mysqld.sock"}, "/var/run/mysqld/mysqld.sock"},
+	}
+	for _, tt := range tests {
+		if got := socketPath(&tt.cfg); got != tt.want {
+			t.Errorf("socketPath(%s, %q, %d) = %q, want %q", tt.cfg.Driver, tt.cfg.Socket, tt.cfg.Port, got, tt.want)
+		}
+	}
+}
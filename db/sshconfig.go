@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+// sshConfigPath is the OpenSSH client config consulted for `alias`.
+var sshConfigPath = "~/.ssh/config"
+
+// maxSSHConfigDepth bounds nested Include directives, as ssh does.
+const maxSSHConfigDepth = 16
+
+// sshHostConfig is the subset of an ssh_config Host entry lazyadmin uses.
+type sshHostConfig struct {
+	HostName      string
+	Port          string
+	User          string
+	ProxyJump     string
+	IdentityFiles []string
+}
+
+// resolveSSHConfig returns a copy of cfg with an `alias` expanded from
+// ~/.ssh/config. Fields set in lazyadmin's own config win over the ones in
+// ssh_config, and cfg itself is never modified.
+func resolveSSHConfig(cfg *config.SSHConfig) (*config.SSHConfig, error) {
+	resolved := *cfg
+
+	if cfg.Alias != "" {
+		hc, err := lookupSSHAlias(config.ExpandHome(sshConfigPath), cfg.Alias)
+		if err != nil {
+			return nil, err
+		}
+
+		if resolved.Host == "" {
+			resolved.Host = hc.HostName
+		}
+		if resolved.Port == 0 && hc.Port != "" {
+			port, err := strconv.Atoi(hc.Port)
+			if err != nil {
+				return nil, fmt.Errorf("ssh config: invalid Port %q for %s", hc.Port, cfg.Alias)
+			}
+			resolved.Port = port
+		}
+		if resolved.User == "" {
+			resolved.User = hc.User
+		}
+		if len(resolved.Jump) == 0 && hc.ProxyJump != "" && !strings.EqualFold(hc.ProxyJump, "none") {
+			if resolved.Jump, err = parseProxyJump(hc.ProxyJump); err != nil {
+				return nil, fmt.Errorf("ssh config: invalid ProxyJump for %s: %w", cfg.Alias, err)
+			}
+		}
+		if resolved.PrivateKey == "" {
+			// ssh tries every IdentityFile; we take the first one present.
+			for _, f := range hc.IdentityFiles {
+				f = expandSSHTokens(f, resolved.Host, resolved.User)
+				if _, err := os.Stat(f); err == nil {
+					resolved.PrivateKey = f
+					break
+				}
+			}
+		}
+	}
+
+	if resolved.Host == "" {
+		return nil, errors.New("ssh host is required")
+	}
+	if resolved.Port == 0 {
+		resolved.Port = 22
+	}
+	if resolved.User == "" {
+		if u, err := user.Current(); err == nil {
+			resolved.User = u.Username
+		}
+	}
+	return &resolved, nil
+}
+
+// lookupSSHAlias reads the ssh_config at file and collects the settings that
+// apply to alias. Like ssh, the first value found for a keyword wins, except
+// IdentityFile which accumulates. A missing file is not an error: the alias
+// is then used as the host name.
+func lookupSSHAlias(file, alias string) (sshHostConfig, error) {
+	var hc sshHostConfig
+	if err := parseSSHConfig(file, alias, &hc, 0); err != nil {
+		return hc, err
+	}
+	if hc.HostName == "" {
+		hc.HostName = alias
+	}
+	hc.HostName = strings.ReplaceAll(hc.HostName, "%h", alias)
+	return hc, nil
+}
+
+func parseSSHConfig(file, alias string, hc *sshHostConfig, depth int) error {
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("ssh config: too many nested includes at %s", file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("ssh config: %w", err)
+	}
+	defer f.Close()
+
+	// Settings before the first Host line apply to every host.
+	matching := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		keyword, args := splitSSHConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			matching = matchSSHHost(alias, args)
+			continue
+		case "match":
+			// Match blocks need ssh's full evaluation rules; ignore them.
+			matching = false
+			continue
+		}
+		if !matching || len(args) == 0 {
+			continue
+		}
+
+		switch keyword {
+		case "include":
+			for _, pattern := range args {
+				pattern = config.ExpandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(config.ExpandHome("~/.ssh"), pattern)
+				}
+				files, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("ssh config: bad Include %q: %w", pattern, err)
+				}
+				for _, inc := range files {
+					if err := parseSSHConfig(inc, alias, hc, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		case "hostname":
+			if hc.HostName == "" {
+				hc.HostName = args[0]
+			}
+		case "port":
+			if hc.Port == "" {
+				hc.Port = args[0]
+			}
+		case "user":
+			if hc.User == "" {
+				hc.User = args[0]
+			}
+		case "proxyjump":
+			if hc.ProxyJump == "" {
+				hc.ProxyJump = args[0]
+			}
+		case "identityfile":
+			hc.IdentityFiles = append(hc.IdentityFiles, args[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ssh config: %w", err)
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lower-cased keyword and arguments of one
+// ssh_config line. Keywords may be separated from their arguments by
+// whitespace or "=", and arguments may be double-quoted.
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		if rest[0] == '"' {
+			if i := strings.IndexByte(rest[1:], '"'); i >= 0 {
+				args = append(args, rest[1:i+1])
+				rest = rest[i+2:]
+				continue
+			}
+		}
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			args = append(args, rest)
+			break
+		}
+		args = append(args, rest[:i])
+		rest = rest[i:]
+	}
+	return keyword, args
+}
+
+// parseProxyJump turns a ProxyJump value ("[user@]host[:port],...") into
+// jump hosts. Each host is used as an alias, so it may have its own entry
+// in ~/.ssh/config, as with ssh.
+func parseProxyJump(value string) ([]config.SSHConfig, error) {
+	var jumps []config.SSHConfig
+	for _, hop := range strings.Split(value, ",") {
+		hop = strings.TrimPrefix(strings.TrimSpace(hop), "ssh://")
+		var jump config.SSHConfig
+		if i := strings.LastIndex(hop, "@"); i >= 0 {
+			jump.User, hop = hop[:i], hop[i+1:]
+		}
+		if host, port, err := net.SplitHostPort(hop); err == nil {
+			n, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("bad port in %q", hop)
+			}
+			hop, jump.Port = host, n
+		}
+		if hop == "" {
+			return nil, fmt.Errorf("empty host in %q", value)
+		}
+		jump.Alias = hop
+		jumps = append(jumps, jump)
+	}
+	return jumps, nil
+}
+
+// matchSSHHost reports whether alias matches a Host line's patterns. A
+// matching negated pattern ("!name") rules the entry out.
+func matchSSHHost(alias string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(p, "!"), alias); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// expandSSHTokens expands ~ and the common % tokens in an IdentityFile.
+func expandSSHTokens(s, host, remoteUser string) string {
+	s = config.ExpandHome(s)
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	home, _ := os.UserHomeDir()
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", host,
+		"%r", remoteUser,
+		"%u", localUser,
+	).Replace(s)
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+)
+
+func TestResolveSSHAlias(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "bastion_ed25519")
+	if err := os.WriteFile(key, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte(`
+Host bastion
+    Port 2222
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshConfig := filepath.Join(dir, "config")
+	if err := os.WriteFile(sshConfig, []byte(`
+Include `+filepath.Join(dir, "extra.conf")+`
+
+Host bastion !bastion-old
+    HostName = bastion.example.com
+    User ops
+    ProxyJump jumper@gw.example.com:2022,gw2
+    IdentityFile `+filepath.Join(dir, "missing")+`
+    IdentityFile "`+key+`"
+
+Host bastion-old
+    HostName old.example.com
+
+Host *
+    User fallback
+    Port 2200
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := sshConfigPath
+	sshConfigPath = sshConfig
+	t.Cleanup(func() { sshConfigPath = old })
+
+	got, err := resolveSSHConfig(&config.SSHConfig{Alias: "bastion"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.SSHConfig{
+		Alias: "bastion", Host: "bastion.example.com", Port: 2222, User: "ops", PrivateKey: key,
+		Jump: []config.SSHConfig{{Alias: "gw.example.com", User: "jumper", Port: 2022}, {Alias: "gw2"}},
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("resolved = %+v, want %+v", *got, want)
+	}
+
+	// Explicit settings win over ssh_config.
+	got, err = resolveSSHConfig(&config.SSHConfig{Alias: "bastion", User: "me", Port: 22})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User != "me" || got.Port != 22 || got.Host != "bastion.example.com" {
+		t.Errorf("explicit fields overridden: %+v", *got)
+	}
+
+	// Negated patterns exclude an entry; unmatched aliases are host names.
+	got, err = resolveSSHConfig(&config.SSHConfig{Alias: "bastion-old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != "old.example.com" || got.User != "fallback" || got.Port != 2200 {
+		t.Errorf("bastion-old resolved = %+v", *got)
+	}
+	got, err = resolveSSHConfig(&config.SSHConfig{Alias: "db.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != "db.internal" {
+		t.Errorf("unknown alias host = %q, want db.internal", got.Host)
+	}
+}
@@ -9,6 +9,10 @@ func isSQLite(driver string) bool {
 	return driver == "sqlite" || driver == "sqlite3"
 }
 
+func isPostgres(driver string) bool {
+	return driver == "postgres" || driver == "postgresql"
+}
+
 func ValidateDriver(driver string) error {
 	validDrivers := []string{"sqlite", "sqlite3", "postgres", "postgresql", "mysql"}
 	for _, v := range validDrivers {
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/audit"
 	"github.com/qyinm/lazyadmin/config"
-	"github.com/qyinm/lazyadmin/db"
 	"github.com/qyinm/lazyadmin/ui"
 )
 
 func main() {
+	role := flag.String("role", "", "role to use instead of your own; it may not allow more")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [--role name] [config.yaml]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	configPath := "admin.yaml"
-	if len(os.Args) > 1 {
-		configPath = os.Args[1]
+	if flag.NArg() > 0 {
+		configPath = flag.Arg(0)
 	}
 
 	cfg, err := config.Load(configPath)
@@ -22,17 +30,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	conn, err := db.Connect(&cfg.Database)
+	access, err := cfg.Access(audit.CurrentUser(), *role)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error connecting to database: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-	defer conn.Close()
 
-	m := ui.NewModel(cfg, configPath, conn.DB)
+	m := ui.NewModel(cfg, configPath, access)
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if fm, ok := final.(ui.Model); ok {
+		fm.Close()
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running program: %v\n", err)
 		os.Exit(1)
 	}
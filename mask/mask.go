@@ -0,0 +1,52 @@
+// Package mask redacts sensitive values before they are shown.
+package mask
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ways a column can be masked.
+const (
+	Full    = "full"    // replace the whole value
+	Partial = "partial" // keep enough to recognise it: j***@x.com, ***1234
+	Hash    = "hash"    // replace with a short digest, so equal values still match
+	Hide    = "hide"    // drop the column altogether
+)
+
+// Modes lists every valid mask.
+var Modes = []string{Full, Partial, Hash, Hide}
+
+// Value masks v with mode. A hidden column has no value to show, so Hide
+// masks like Full wherever the value has to appear anyway.
+func Value(mode, v string) string {
+	switch mode {
+	case Partial:
+		return partial(v)
+	case Hash:
+		sum := sha256.Sum256([]byte(v))
+		return "#" + hex.EncodeToString(sum[:6])
+	}
+	return "********"
+}
+
+// partial keeps the first character and domain of an email address, the
+// last four characters of a long value, or just the first character of a
+// short one.
+func partial(v string) string {
+	if local, domain, ok := strings.Cut(v, "@"); ok && local != "" {
+		first, _ := utf8.DecodeRuneInString(local)
+		return string(first) + "***@" + domain
+	}
+	if n := utf8.RuneCountInString(v); n >= 8 {
+		r := []rune(v)
+		return "***" + string(r[n-4:])
+	}
+	if v == "" {
+		return v
+	}
+	first, _ := utf8.DecodeRuneInString(v)
+	return string(first) + "***"
+}
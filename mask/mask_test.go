@@ -0,0 +1,26 @@
+package mask
+
+import "testing"
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		mode, in, want string
+	}{
+		{Full, "secret", "********"},
+		{Hide, "secret", "********"},
+		{Partial, "jane@example.com", "j***@example.com"},
+		{Partial, "4111111111111111", "***1111"},
+		{Partial, "Jane", "J***"},
+		{Partial, "", ""},
+	}
+	for _, tt := range tests {
+		if got := Value(tt.mode, tt.in); got != tt.want {
+			t.Errorf("Value(%s, %q) = %q, want %q", tt.mode, tt.in, got, tt.want)
+		}
+	}
+
+	a, b := Value(Hash, "jane@example.com"), Value(Hash, "john@example.com")
+	if a == b || a != Value(Hash, "jane@example.com") || len(a) != 13 {
+		t.Errorf("hashes %q and %q should be stable, distinct and short", a, b)
+	}
+}
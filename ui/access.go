@@ -0,0 +1,36 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/config"
+)
+
+// allow checks the current role against action on table; see
+// config.Access.Action.
+func (m Model) allow(action, table string) error {
+	return m.access.Action(action, table)
+}
+
+// allowManage checks that the role may change connections; see
+// config.Access.ManageConnections.
+func (m Model) allowManage() error {
+	return m.access.ManageConnections()
+}
+
+// deny explains in the status bar why the role refused what was asked,
+// leaving the view as it was.
+func (m Model) deny(err error) (tea.Model, tea.Cmd) {
+	m.statusMsg = "⛔ " + err.Error()
+	return m, nil
+}
+
+// undoAction is the action undoing a change of kind amounts to.
+func undoAction(kind string) string {
+	switch kind {
+	case "INSERT":
+		return config.ActionDelete
+	case "DELETE":
+		return config.ActionInsert
+	}
+	return config.ActionUpdate
+}
@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// conflictMsg reports an edit that didn't save because someone else changed
+// the row after it was read.
+type conflictMsg struct {
+	table    string
+	pkColumn string
+	pkValue  interface{}
+	original map[string]interface{} // the row the edit started from
+	mine     map[string]interface{} // the edited columns
+	stale    *db.StaleError
+}
+
+// saveEdit updates a row edited from original, setting the status message.
+// If the row changed in the meantime, the returned command reports the
+// conflict so it can be shown.
+func (m *Model) saveEdit(table, pkColumn string, pkValue interface{}, original, data map[string]interface{}) tea.Cmd {
+	err := m.updateRecord(table, pkColumn, pkValue, data, m.expected(original, data))
+	var stale *db.StaleError
+	switch {
+	case errors.As(err, &stale):
+		m.err = err
+		m.statusMsg = "Update failed: " + err.Error()
+		if stale.Current == nil {
+			return nil
+		}
+		msg := conflictMsg{table: table, pkColumn: pkColumn, pkValue: pkValue, original: original, mine: data, stale: stale}
+		return func() tea.Msg { return msg }
+	case err != nil:
+		m.err = err
+		m.statusMsg = "Update failed: " + err.Error()
+	default:
+		m.statusMsg = "Record updated successfully" + m.stagedSuffix()
+	}
+	return nil
+}
+
+// showConflict lays out the original row, the edit and the row as it is
+// now, and offers to save the edit over the other change. On production
+// the UPDATE that would do so is previewed, as for any other edit.
+func (m Model) showConflict(msg conflictMsg) (tea.Model, tea.Cmd) {
+	theirs := msg.stale.Current
+	stmt, err := db.BuildCheckedUpdate(m.driver, msg.table, msg.pkColumn, msg.pkValue, msg.mine, m.expected(theirs, msg.mine))
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	m.confirmMsg = fmt.Sprintf("⚠ %s %s = %v was changed by someone else while you edited it.\n\n%s",
+		msg.table, msg.pkColumn, msg.pkValue, renderConflict(m.maskRecord(msg.original), m.maskRecord(msg.mine), m.maskRecord(theirs)))
+	m.confirmStmt = &stmt
+	m.confirmTable = msg.table
+	if m.production() {
+		m.confirmMsg += "\n" + m.productionPreview("Save mine over theirs?", stmt)
+	}
+	m.confirmMsg += "\ny: Save mine over theirs  n: Keep theirs"
+	m.confirmReturn = FocusTable
+	m.confirmAction = func(m *Model) tea.Cmd {
+		// Theirs is now what the edit has to start from.
+		return m.saveEdit(msg.table, msg.pkColumn, msg.pkValue, theirs, msg.mine)
+	}
+	m.focus = FocusConfirm
+	return m, nil
+}
+
+// renderConflict tabulates the columns that differ between original, mine
+// and theirs. Columns that both sides changed are marked with !.
+func renderConflict(original, mine, theirs map[string]interface{}) string {
+	var cols []string
+	for col := range theirs {
+		_, edited := mine[col]
+		if edited || toString(theirs[col]) != toString(original[col]) {
+			cols = append(cols, col)
+		}
+	}
+	sort.Strings(cols)
+
+	const width = 20
+	cell := func(s string) string {
+		if r := []rune(s); len(r) > width {
+			s = string(r[:width-1]) + "…"
+		}
+		return fmt.Sprintf("%-*s", width, s)
+	}
+
+	var b strings.Builder
+	b.WriteString("  " + cell("column") + " " + cell("original") + " " + cell("mine") + " " + cell("theirs") + "\n")
+	for _, col := range cols {
+		orig, edit, now := toString(original[col]), toString(original[col]), toString(theirs[col])
+		mark := " "
+		if v, ok := mine[col]; ok {
+			edit = toString(v)
+			if now != orig && now != edit {
+				mark = "!"
+			}
+		}
+		b.WriteString(mark + " " + cell(col) + " " + cell(orig) + " " + cell(edit) + " " + cell(now) + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+func TestConflictOnProductionPreviewsSave(t *testing.T) {
+	m := browseUsers(t, "production")
+	if _, err := m.db.Exec("UPDATE users SET email = 'theirs@example.com' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	msg := conflictMsg{
+		table:    "users",
+		pkColumn: "id",
+		pkValue:  "1",
+		original: map[string]interface{}{"id": "1", "email": "a@example.com", "note": nil},
+		mine:     map[string]interface{}{"email": "mine@example.com"},
+		stale:    &db.StaleError{Current: map[string]interface{}{"id": "1", "email": "theirs@example.com", "note": nil}},
+	}
+
+	next, _ := m.showConflict(msg)
+	m = next.(Model)
+	if !strings.Contains(m.confirmMsg, "UPDATE") || !strings.Contains(m.confirmMsg, "Rows affected (estimate): 1") {
+		t.Errorf("conflict on production doesn't preview the update:\n%s", m.confirmMsg)
+	}
+	if m.confirmStmt == nil {
+		t.Error("conflict has no statement for Ctrl+P and Ctrl+R")
+	}
+
+	next, _ = m.updateConfirm(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = next.(Model)
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	if got := userIDs(t, m, "email = 'mine@example.com'"); len(got) != 1 {
+		t.Error("saving mine over theirs didn't update the row")
+	}
+}
@@ -0,0 +1,709 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+const (
+	connTestTimeout = 10 * time.Second
+	connectTimeout  = 30 * time.Second
+)
+
+type connStatus int
+
+const (
+	connIdle connStatus = iota
+	connConnecting
+	connConnected
+	connFailed
+)
+
+// connState tracks the most recent connection attempt for one label.
+type connState struct {
+	status connStatus
+	err    error
+}
+
+// connectedMsg carries the result of a background connection attempt.
+type connectedMsg struct {
+	cfg  config.DatabaseConfig
+	conn *db.Connection
+	err  error
+}
+
+// tunnelEventMsg carries a state change or error from a session's SSH
+// tunnel.
+type tunnelEventMsg struct {
+	tunnel *db.SSHTunnel
+	event  db.TunnelEvent
+}
+
+// connTestMsg carries the result of testing the connection form.
+type connTestMsg struct {
+	steps []db.DiagnosticStep
+}
+
+type formFieldInfo struct {
+	label       string
+	placeholder string
+	isPassword  bool
+	section     string
+}
+
+var connFormFieldInfos = []formFieldInfo{
+	{label: "Label"},
+	{label: "Driver"},
+	{label: "Environment", placeholder: "dev, staging, prod or custom"},
+	{label: "Host"},
+	{label: "Port", placeholder: "5432"},
+	{label: "User"},
+	{label: "Password", isPassword: true},
+	{label: "Database Name"},
+	{label: "Path (SQLite)"},
+	{label: "Socket", placeholder: "Unix socket path, instead of host/port"},
+	{label: "SSL Mode", placeholder: "disable", section: "TLS"},
+	{label: "SSH Alias", placeholder: "Host from ~/.ssh/config", section: "SSH Tunnel"},
+	{label: "SSH Host", section: "SSH Tunnel"},
+	{label: "SSH Port", placeholder: "22", section: "SSH Tunnel"},
+	{label: "SSH User", section: "SSH Tunnel"},
+	{label: "SSH Password", isPassword: true, section: "SSH Tunnel"},
+	{label: "SSH Private Key", placeholder: "~/.ssh/id_rsa", section: "SSH Tunnel"},
+}
+
+func newConnFormInputs() []textinput.Model {
+	inputs := make([]textinput.Model, len(connFormFieldInfos))
+	for i, info := range connFormFieldInfos {
+		ti := textinput.New()
+		ti.Cursor.Style = lipgloss.NewStyle().Foreground(DraculaPink)
+		ti.Prompt = info.label + ": "
+		ti.PromptStyle = lipgloss.NewStyle().Foreground(DraculaCyan)
+		ti.Placeholder = info.placeholder
+
+		if info.isPassword {
+			ti.EchoMode = textinput.EchoPassword
+			ti.EchoCharacter = '•'
+		}
+
+		inputs[i] = ti
+	}
+	return inputs
+}
+
+func (m Model) connectionItems() []list.Item {
+	var items []list.Item
+	for _, c := range m.config.Connections {
+		items = append(items, ViewItem{
+			title:       c.Label + environmentTag(c),
+			description: fmt.Sprintf("%s%s %s (%s)", statusBadge(m.connStates[c.Label].status), readOnlyBadge(c), c.Driver, connectionTarget(c)),
+			query:       c.Name,
+		})
+	}
+	return items
+}
+
+// connectionTarget is where c connects to, for display.
+func connectionTarget(c config.DatabaseConfig) string {
+	if c.Socket != "" {
+		return c.Socket
+	}
+	return c.Host
+}
+
+func readOnlyBadge(c config.DatabaseConfig) string {
+	if c.ReadOnly {
+		return " 🔒"
+	}
+	return ""
+}
+
+func statusBadge(status connStatus) string {
+	switch status {
+	case connConnecting:
+		return "🟡"
+	case connConnected:
+		return "🟢"
+	case connFailed:
+		return "🔴"
+	default:
+		return "⚪"
+	}
+}
+
+func (m *Model) refreshConnectionList() {
+	m.connSidebar.SetItems(m.connectionItems())
+}
+
+// connectCmd connects to cfg in the background, asking prompt to answer
+// any keyboard-interactive SSH challenges.
+func connectCmd(cfg config.DatabaseConfig, prompt db.Prompter) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		defer cancel()
+		conn, err := db.ConnectContext(db.WithPrompter(ctx, prompt), &cfg)
+		return connectedMsg{cfg: cfg, conn: conn, err: err}
+	}
+}
+
+// connect starts connecting to cfg in the background. The UI stays usable
+// meanwhile and the result arrives as a connectedMsg.
+func (m Model) connect(cfg config.DatabaseConfig) (tea.Model, tea.Cmd) {
+	if m.connStates[cfg.Label].status == connConnecting {
+		return m, nil
+	}
+	return m, m.startConnect(cfg)
+}
+
+func (m *Model) startConnect(cfg config.DatabaseConfig) tea.Cmd {
+	m.pendingConn = cfg.Label
+	m.connStates[cfg.Label] = connState{status: connConnecting}
+	m.refreshConnectionList()
+	m.statusMsg = fmt.Sprintf("Connecting to %s...", cfg.Label)
+	return connectCmd(cfg, newPrompter(m.authRequests))
+}
+
+func (m Model) handleConnected(msg connectedMsg) (tea.Model, tea.Cmd) {
+	label := msg.cfg.Label
+	requested := label == m.pendingConn
+	if requested {
+		m.pendingConn = ""
+	}
+
+	// Don't pull the user out of a form they opened while connecting.
+	busy := m.mode == ModeConnectionForm || m.showForm || m.focus == FocusConfirm
+
+	if msg.err != nil {
+		m.connStates[label] = connState{status: connFailed, err: msg.err}
+		m.refreshConnectionList()
+		if !requested {
+			return m, nil
+		}
+
+		var hostKeyErr *db.UnknownHostKeyError
+		if errors.As(msg.err, &hostKeyErr) && !busy {
+			return m.showTrustHostKeyConfirm(msg.cfg, hostKeyErr)
+		}
+		m.err = fmt.Errorf("%s: %w", label, msg.err)
+		return m, nil
+	}
+
+	if m.sessionIndex(label) >= 0 {
+		msg.conn.Close()
+		return m, nil
+	}
+
+	tables, err := db.GetTables(msg.conn.DB, msg.cfg.Driver)
+	if err != nil {
+		msg.conn.Close()
+		m.connStates[label] = connState{status: connFailed, err: err}
+		m.refreshConnectionList()
+		m.err = err
+		return m, nil
+	}
+
+	s := newSession(msg.cfg, msg.conn)
+	s.tables = tables
+	s.mode = ModeTableBrowser
+	m.connStates[label] = connState{status: connConnected}
+	m.refreshConnectionList()
+
+	if !requested || busy {
+		m.openSession(s, false)
+		m.statusMsg = fmt.Sprintf("Connected to %s (press ] to switch)", label)
+		return m, watchTunnel(msg.conn)
+	}
+
+	m.openSession(s, true)
+	m.err = nil
+	m.statusMsg = fmt.Sprintf("Connected to %s", label)
+	m.focus = FocusSidebar
+	return m, watchTunnel(msg.conn)
+}
+
+// showTrustHostKeyConfirm asks whether to trust an SSH server key that is
+// not in known_hosts yet, and reconnects once it has been recorded.
+func (m Model) showTrustHostKeyConfirm(cfg config.DatabaseConfig, hostKeyErr *db.UnknownHostKeyError) (tea.Model, tea.Cmd) {
+	m.confirmMsg = fmt.Sprintf("The authenticity of SSH host %s can't be established.\n\n"+
+		"%s key fingerprint is %s.\n\n"+
+		"Trust this key and add it to %s? (y/n)",
+		hostKeyErr.Host, hostKeyErr.Key.Type(), hostKeyErr.Fingerprint(), hostKeyErr.KnownHostsPath)
+	m.confirmReturn = m.focus
+	m.confirmAction = func(m *Model) tea.Cmd {
+		if err := db.TrustHostKey(hostKeyErr); err != nil {
+			m.err = err
+			return nil
+		}
+		m.err = nil
+		return m.startConnect(cfg)
+	}
+	m.focus = FocusConfirm
+	return m, nil
+}
+
+// saveConnections persists the connection list and refreshes the pane.
+func (m *Model) saveConnections() error {
+	if err := config.Save(m.configPath, m.config); err != nil {
+		return err
+	}
+	m.snapshotConfig()
+	m.refreshConnectionList()
+	return nil
+}
+
+func (m Model) showConnectionForm(index int) (tea.Model, tea.Cmd) {
+	if err := m.allowManage(); err != nil {
+		return m.deny(err)
+	}
+	for i := range m.connForm {
+		m.connForm[i].SetValue("")
+		m.connForm[i].Blur()
+	}
+
+	m.editingConn = ""
+	m.connTesting = false
+	m.connTestSteps = nil
+	if index >= 0 {
+		m.editingConn = m.config.Connections[index].Label
+		m.fillConnectionForm(m.config.Connections[index])
+	}
+
+	m.mode = ModeConnectionForm
+	m.connForm[0].Focus()
+	return m, textinput.Blink
+}
+
+// editedConnection returns the index of the connection the form edits,
+// or -1 for a new one. It fails if that connection is no longer in the
+// config.
+func (m Model) editedConnection() (int, error) {
+	if m.editingConn == "" {
+		return -1, nil
+	}
+	if i := connectionIndex(m.config, m.editingConn); i >= 0 {
+		return i, nil
+	}
+	return -1, fmt.Errorf("connection %q was removed from the config while it was being edited", m.editingConn)
+}
+
+func (m *Model) fillConnectionForm(c config.DatabaseConfig) {
+	m.setConnFormFieldValue("Label", c.Label)
+	m.setConnFormFieldValue("Driver", c.Driver)
+	m.setConnFormFieldValue("Environment", c.Environment)
+	m.setConnFormFieldValue("Host", c.Host)
+	if c.Port != 0 {
+		m.setConnFormFieldValue("Port", strconv.Itoa(c.Port))
+	}
+	m.setConnFormFieldValue("User", c.User)
+	m.setConnFormFieldValue("Password", c.Password)
+	m.setConnFormFieldValue("Database Name", c.Name)
+	m.setConnFormFieldValue("Path (SQLite)", c.Path)
+	m.setConnFormFieldValue("Socket", c.Socket)
+	m.setConnFormFieldValue("SSL Mode", c.SSLMode)
+
+	if c.SSH != nil {
+		m.setConnFormFieldValue("SSH Alias", c.SSH.Alias)
+		m.setConnFormFieldValue("SSH Host", c.SSH.Host)
+		if c.SSH.Port != 0 {
+			m.setConnFormFieldValue("SSH Port", strconv.Itoa(c.SSH.Port))
+		}
+		m.setConnFormFieldValue("SSH User", c.SSH.User)
+		m.setConnFormFieldValue("SSH Password", c.SSH.Password)
+		m.setConnFormFieldValue("SSH Private Key", c.SSH.PrivateKey)
+	}
+}
+
+func (m Model) updateConnectionForm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc":
+			m.mode = ModeView
+			m.editingConn = ""
+			return m, nil
+		case "tab", "enter", "shift+tab", "up", "down":
+			focusedIndex := -1
+			for i := range m.connForm {
+				if m.connForm[i].Focused() {
+					focusedIndex = i
+					break
+				}
+			}
+
+			if msg.String() == "enter" && focusedIndex == len(m.connForm)-1 {
+				return m.submitConnectionForm()
+			}
+
+			if focusedIndex >= 0 {
+				m.connForm[focusedIndex].Blur()
+				next := (focusedIndex + 1) % len(m.connForm)
+				if msg.String() == "shift+tab" || msg.String() == "up" {
+					next = (focusedIndex - 1 + len(m.connForm)) % len(m.connForm)
+				}
+				m.connForm[next].Focus()
+			}
+			return m, nil
+		case "ctrl+s":
+			return m.submitConnectionForm()
+		case "ctrl+t":
+			return m.testConnectionForm()
+		}
+	}
+
+	cmds := make([]tea.Cmd, len(m.connForm))
+	for i := range m.connForm {
+		m.connForm[i], cmds[i] = m.connForm[i].Update(msg)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+func (m Model) getConnFormFieldValue(label string) string {
+	for i, info := range connFormFieldInfos {
+		if info.label == label {
+			return m.connForm[i].Value()
+		}
+	}
+	return ""
+}
+
+func (m *Model) setConnFormFieldValue(label, value string) {
+	for i, info := range connFormFieldInfos {
+		if info.label == label {
+			m.connForm[i].SetValue(value)
+			return
+		}
+	}
+}
+
+func parsePortField(label, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s number: %s", strings.ToLower(label), value)
+	}
+	return port, nil
+}
+
+// connectionFromForm builds a connection from the form fields without
+// touching the model.
+func (m Model) connectionFromForm() (config.DatabaseConfig, error) {
+	port, err := parsePortField("Port", m.getConnFormFieldValue("Port"))
+	if err != nil {
+		return config.DatabaseConfig{}, err
+	}
+
+	conn := config.DatabaseConfig{
+		Label:       m.getConnFormFieldValue("Label"),
+		Driver:      m.getConnFormFieldValue("Driver"),
+		Environment: m.getConnFormFieldValue("Environment"),
+		Host:        m.getConnFormFieldValue("Host"),
+		Port:        port,
+		User:        m.getConnFormFieldValue("User"),
+		Password:    m.getConnFormFieldValue("Password"),
+		Name:        m.getConnFormFieldValue("Database Name"),
+		Path:        m.getConnFormFieldValue("Path (SQLite)"),
+		Socket:      m.getConnFormFieldValue("Socket"),
+		SSLMode:     m.getConnFormFieldValue("SSL Mode"),
+	}
+
+	switch conn.Driver {
+	case "sqlite", "sqlite3", "postgres", "postgresql", "mysql":
+	case "":
+		return conn, fmt.Errorf("driver is required")
+	default:
+		return conn, fmt.Errorf("unsupported driver: %s", conn.Driver)
+	}
+
+	if conn.Label == "" {
+		return conn, fmt.Errorf("label is required")
+	}
+
+	edited, err := m.editedConnection()
+	if err != nil {
+		return conn, err
+	}
+
+	// Pool, session, read-only, audit and mask settings aren't in the form;
+	// keep the edited connection's.
+	if edited >= 0 {
+		old := m.config.Connections[edited]
+		conn.Pool, conn.Session, conn.ReadOnly = old.Pool, old.Session, old.ReadOnly
+		conn.AuditTable, conn.VersionColumn, conn.Masks = old.AuditTable, old.VersionColumn, old.Masks
+	}
+
+	sshAlias := m.getConnFormFieldValue("SSH Alias")
+	if sshHost := m.getConnFormFieldValue("SSH Host"); sshHost != "" || sshAlias != "" {
+		sshPort, err := parsePortField("SSH Port", m.getConnFormFieldValue("SSH Port"))
+		if err != nil {
+			return conn, err
+		}
+		// With an alias, an empty port means "whatever ~/.ssh/config says".
+		if sshPort == 0 && sshAlias == "" {
+			sshPort = 22
+		}
+		// Start from the connection being edited so SSH settings the form
+		// doesn't show (known_hosts, pinned host key, ...) survive an edit.
+		ssh := config.SSHConfig{}
+		if edited >= 0 {
+			if old := m.config.Connections[edited].SSH; old != nil {
+				ssh = *old
+			}
+		}
+		ssh.Alias = sshAlias
+		ssh.Host = sshHost
+		ssh.Port = sshPort
+		ssh.User = m.getConnFormFieldValue("SSH User")
+		ssh.Password = m.getConnFormFieldValue("SSH Password")
+		ssh.PrivateKey = m.getConnFormFieldValue("SSH Private Key")
+		conn.SSH = &ssh
+	}
+
+	return conn, nil
+}
+
+func (m Model) submitConnectionForm() (tea.Model, tea.Cmd) {
+	if err := m.allowManage(); err != nil {
+		return m.deny(err)
+	}
+	newConn, err := m.connectionFromForm()
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	// The config may have been reloaded since the form opened, so the
+	// edited connection is looked up again by label.
+	edited, err := m.editedConnection()
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	for i, c := range m.config.Connections {
+		if c.Label == newConn.Label && i != edited {
+			m.err = fmt.Errorf("a connection named %q already exists", newConn.Label)
+			return m, nil
+		}
+	}
+
+	previous := append([]config.DatabaseConfig(nil), m.config.Connections...)
+	if edited >= 0 {
+		old := m.config.Connections[edited]
+		newConn = old.Edited(newConn)
+		m.config.Connections[edited] = newConn
+		// An open tab keeps its connection until it reconnects, but its
+		// label and settings such as the environment change right away.
+		if i := m.sessionIndex(old.Label); i >= 0 {
+			if old.Label != newConn.Label {
+				m.sessions[i].cfg.Label = newConn.Label
+				m.connStates[newConn.Label] = m.connStates[old.Label]
+				delete(m.connStates, old.Label)
+				if i == m.active {
+					m.connLabel = newConn.Label
+				}
+			}
+			m.sessions[i].applySettings(newConn)
+		}
+		m.statusMsg = "Connection updated"
+	} else {
+		m.config.Connections = append(m.config.Connections, newConn)
+		m.statusMsg = "Connection added"
+	}
+
+	if err := m.saveConnections(); err != nil {
+		m.config.Connections = previous
+		m.err = err
+		return m, nil
+	}
+
+	m.mode = ModeView
+	m.editingConn = ""
+	m.err = nil
+
+	for i := range m.connForm {
+		m.connForm[i].SetValue("")
+	}
+
+	return m, nil
+}
+
+// testConnectionForm runs db.Diagnose against the form values in the
+// background; the result arrives as a connTestMsg.
+func (m Model) testConnectionForm() (tea.Model, tea.Cmd) {
+	conn, err := m.connectionFromForm()
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	if m.connTesting {
+		return m, nil
+	}
+
+	m.err = nil
+	m.connTesting = true
+	m.connTestSteps = nil
+	prompt := newPrompter(m.authRequests)
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), connTestTimeout)
+		defer cancel()
+		return connTestMsg{steps: db.Diagnose(db.WithPrompter(ctx, prompt), &conn)}
+	}
+}
+
+func (m Model) duplicateConnection() (tea.Model, tea.Cmd) {
+	if err := m.allowManage(); err != nil {
+		return m.deny(err)
+	}
+	index := m.connSidebar.Index()
+	if index < 0 || index >= len(m.config.Connections) {
+		return m, nil
+	}
+
+	src := m.config.Connections[index]
+	dup := src.Duplicate(m.uniqueConnectionLabel(src.Label + " (copy)"))
+
+	previous := append([]config.DatabaseConfig(nil), m.config.Connections...)
+	conns := append([]config.DatabaseConfig(nil), m.config.Connections[:index+1]...)
+	conns = append(conns, dup)
+	m.config.Connections = append(conns, m.config.Connections[index+1:]...)
+
+	if err := m.saveConnections(); err != nil {
+		m.config.Connections = previous
+		m.err = err
+		return m, nil
+	}
+
+	m.connSidebar.Select(index + 1)
+	m.statusMsg = fmt.Sprintf("Duplicated as %s", dup.Label)
+	return m, nil
+}
+
+func (m Model) uniqueConnectionLabel(base string) string {
+	label := base
+	for n := 2; connectionIndex(m.config, label) >= 0; n++ {
+		label = fmt.Sprintf("%s %d", base, n)
+	}
+	return label
+}
+
+func (m Model) showDeleteConnectionConfirm() (tea.Model, tea.Cmd) {
+	if err := m.allowManage(); err != nil {
+		return m.deny(err)
+	}
+	index := m.connSidebar.Index()
+	if index < 0 || index >= len(m.config.Connections) {
+		return m, nil
+	}
+	label := m.config.Connections[index].Label
+
+	m.confirmMsg = fmt.Sprintf("Delete connection %q? (y/n)", label)
+	m.confirmReturn = FocusConnections
+	m.confirmAction = func(m *Model) tea.Cmd {
+		i := connectionIndex(m.config, label)
+		if i < 0 {
+			return nil
+		}
+		if err := m.allowManage(); err != nil {
+			m.statusMsg = "⛔ " + err.Error()
+			return nil
+		}
+
+		previous := append([]config.DatabaseConfig(nil), m.config.Connections...)
+		m.config.RemoveConnection(i)
+		if err := m.saveConnections(); err != nil {
+			m.config.Connections = previous
+			m.err = err
+			return nil
+		}
+
+		m.closeSession(m.sessionIndex(label))
+		m.statusMsg = fmt.Sprintf("Connection %s deleted", label)
+		if connectionIndex(m.config, label) >= 0 {
+			m.statusMsg = fmt.Sprintf("Connection %s reverted to the shared definition", label)
+		}
+		return nil
+	}
+	m.focus = FocusConfirm
+
+	return m, nil
+}
+
+func (m Model) moveConnection(delta int) (tea.Model, tea.Cmd) {
+	if err := m.allowManage(); err != nil {
+		return m.deny(err)
+	}
+	index := m.connSidebar.Index()
+	target := index + delta
+	if index < 0 || target < 0 || target >= len(m.config.Connections) {
+		return m, nil
+	}
+
+	conns := m.config.Connections
+	conns[index], conns[target] = conns[target], conns[index]
+	if err := m.saveConnections(); err != nil {
+		conns[index], conns[target] = conns[target], conns[index]
+		m.err = err
+		return m, nil
+	}
+
+	m.connSidebar.Select(target)
+	return m, nil
+}
+
+func (m Model) viewConnectionForm() string {
+	var b string
+	title := "New Connection"
+	if m.editingConn != "" {
+		title = "Edit Connection"
+	}
+	b += TitleStyle.Render(title) + "\n\n"
+
+	section := ""
+	for i, info := range connFormFieldInfos {
+		if info.section != section {
+			section = info.section
+			b += "\n" + HelpKeyStyle.Render(section) + "\n"
+		}
+		b += m.connForm[i].View() + "\n"
+	}
+
+	if m.connTesting {
+		b += "\n" + HelpKeyStyle.Render("Testing connection...") + "\n"
+	} else if len(m.connTestSteps) > 0 {
+		b += "\n" + HelpKeyStyle.Render("Test Results") + "\n"
+		for _, step := range m.connTestSteps {
+			b += fmt.Sprintf("%s %s: %s\n", diagnosticIcon(step.Status), step.Name, step.Detail)
+		}
+	}
+
+	if m.err != nil {
+		b += "\n❌ " + m.err.Error() + "\n"
+	}
+
+	b += "\n" + EmptyStateStyle.Render("Ctrl+S: Save • Ctrl+T: Test • Tab/↓↑: Navigate • Esc: Cancel")
+	return b
+}
+
+func diagnosticIcon(status db.DiagnosticStatus) string {
+	switch status {
+	case db.DiagnosticPass:
+		return "✅"
+	case db.DiagnosticWarn:
+		return "⚠️"
+	case db.DiagnosticFail:
+		return "❌"
+	default:
+		return "⏭️"
+	}
+}
@@ -0,0 +1,173 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+func TestConnectionFormKeepsHiddenSettings(t *testing.T) {
+	orig := config.DatabaseConfig{
+		Label:         "Production",
+		Driver:        "sqlite",
+		Path:          "prod.db",
+		ReadOnly:      true,
+		AuditTable:    "lazyadmin_audit",
+		VersionColumn: "updated_at",
+		Masks:         []config.Mask{{Table: "users", Column: "email", Mode: "partial"}},
+	}
+	m := NewModel(&config.Config{Connections: []config.DatabaseConfig{orig}}, "", config.Access{})
+	m.editingConn = "Production"
+	m.fillConnectionForm(orig)
+	m.setConnFormFieldValue("Path (SQLite)", "prod-copy.db")
+
+	conn, err := m.connectionFromForm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Path != "prod-copy.db" {
+		t.Errorf("Path = %q, want the edited value", conn.Path)
+	}
+	if !conn.ReadOnly || conn.AuditTable != orig.AuditTable || conn.VersionColumn != orig.VersionColumn {
+		t.Errorf("settings outside the form were lost: %+v", conn)
+	}
+	if !reflect.DeepEqual(conn.Masks, orig.Masks) {
+		t.Errorf("Masks = %+v, want %+v", conn.Masks, orig.Masks)
+	}
+}
+
+func TestConnectionFormKeepsFileValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lazyadmin.yaml")
+	data := "connections:\n  - label: Production\n    driver: postgres\n    host: db.internal\n    user: app\n    password: disk-secret\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+	t.Setenv("LAZYADMIN_CONN_PRODUCTION_PASSWORD", "env-secret")
+	t.Setenv("LAZYADMIN_CONN_PRODUCTION_USER", "env-user")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(cfg, path, config.Access{})
+	m.editingConn = "Production"
+	m.fillConnectionForm(cfg.Connections[0])
+	m.setConnFormFieldValue("Host", "db2.internal")
+	m.setConnFormFieldValue("User", "admin")
+	next, _ := m.submitConnectionForm()
+	if err := next.(Model).err; err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("LAZYADMIN_CONN_PRODUCTION_PASSWORD")
+	os.Unsetenv("LAZYADMIN_CONN_PRODUCTION_USER")
+	onDisk, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := onDisk.Connections[0]
+	if c.Password != "disk-secret" {
+		t.Errorf("saved password = %q, want the one on disk", c.Password)
+	}
+	if c.Host != "db2.internal" || c.User != "admin" {
+		t.Errorf("saved host and user = %q, %q, want the edited values", c.Host, c.User)
+	}
+	if got := saved.Connections[0].Password; got != "env-secret" {
+		t.Errorf("password with the environment = %q, want it overridden", got)
+	}
+}
+
+func TestConnectionFormAfterReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lazyadmin.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+	write := func(data string) *config.Config {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	cfg := write("connections:\n  - label: Staging\n    driver: sqlite\n    path: staging.db\n    read_only: true\n" +
+		"  - label: Local\n    driver: sqlite\n    path: local.db\n")
+
+	m := NewModel(cfg, path, config.Access{})
+	next, _ := m.showConnectionForm(1)
+	m = next.(Model)
+	m.setConnFormFieldValue("Path (SQLite)", "local2.db")
+
+	// A reload drops the connection before the one being edited.
+	m.applyConfig(write("connections:\n  - label: Local\n    driver: sqlite\n    path: local.db\n"))
+	next, _ = m.submitConnectionForm()
+	m = next.(Model)
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	if got := m.config.Connections; len(got) != 1 || got[0].Path != "local2.db" || got[0].ReadOnly {
+		t.Errorf("connections after the edit = %+v, want Local edited on its own", got)
+	}
+
+	// A reload drops the connection being edited.
+	next, _ = m.showConnectionForm(0)
+	m = next.(Model)
+	m.applyConfig(write("connections:\n  - label: Other\n    driver: sqlite\n    path: other.db\n"))
+	next, _ = m.submitConnectionForm()
+	m = next.(Model)
+	if m.err == nil {
+		t.Error("submitting the form for a removed connection succeeded")
+	}
+	if got := m.config.Connections; len(got) != 1 || got[0].Label != "Other" {
+		t.Errorf("connections = %+v, want the reloaded config unchanged", got)
+	}
+}
+
+func TestConnectionFormUpdatesOpenTab(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lazyadmin.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+	data := "connections:\n  - label: Local\n    driver: sqlite\n    path: " + filepath.Join(dir, "local.db") + "\n    environment: dev\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Connect(&cfg.Connections[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m := NewModel(cfg, path, config.Access{})
+	m.openSession(newSession(cfg.Connections[0], conn), true)
+	next, _ := m.showConnectionForm(0)
+	m = next.(Model)
+	m.setConnFormFieldValue("Label", "Primary")
+	m.setConnFormFieldValue("Environment", "production")
+	next, _ = m.submitConnectionForm()
+	m = next.(Model)
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+
+	if !m.production() {
+		t.Error("the open tab isn't treated as production after its environment was changed")
+	}
+	if m.connLabel != "Primary" || m.activeSession().cfg.Label != "Primary" {
+		t.Errorf("open tab labelled %q, want Primary", m.activeSession().cfg.Label)
+	}
+}
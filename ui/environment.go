@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/config"
+)
+
+// environmentColor returns the color for an environment tag, and false for
+// untagged connections.
+func environmentColor(env string) (lipgloss.Color, bool) {
+	switch strings.ToLower(env) {
+	case "":
+		return "", false
+	case "dev", "development", "local":
+		return DraculaGreen, true
+	case "staging", "stage", "test":
+		return DraculaYellow, true
+	case "prod", "production":
+		return DraculaRed, true
+	default:
+		return DraculaCyan, true
+	}
+}
+
+// environmentTag renders c's environment as a colored label, or nothing if
+// it has none.
+func environmentTag(c config.DatabaseConfig) string {
+	color, ok := environmentColor(c.Environment)
+	if !ok {
+		return ""
+	}
+	return " " + lipgloss.NewStyle().
+		Foreground(DraculaBackground).
+		Background(color).
+		Bold(true).
+		Render(" "+strings.ToUpper(c.Environment)+" ")
+}
+
+// tint colors a pane's border after the active connection's environment,
+// so it's obvious at a glance which environment is being edited.
+func (m Model) tint(style lipgloss.Style) lipgloss.Style {
+	s := m.activeSession()
+	if s == nil {
+		return style
+	}
+	if color, ok := environmentColor(s.cfg.Environment); ok {
+		return style.BorderForeground(color)
+	}
+	return style
+}
+
+// production reports whether the active connection is tagged production.
+func (m Model) production() bool {
+	s := m.activeSession()
+	return s != nil && s.cfg.IsProduction()
+}
@@ -30,6 +30,7 @@ type FormModel struct {
 	tableName  string
 	pkColumn   string
 	pkValue    interface{}
+	original   map[string]interface{} // the row as read when editing began
 	width      int
 	height     int
 	submitted  bool
@@ -75,6 +76,7 @@ func NewFormModel(columns []db.ColumnInfo, mode FormMode, tableName, pkColumn st
 		tableName:  tableName,
 		pkColumn:   pkColumn,
 		pkValue:    pkValue,
+		original:   existingData,
 	}
 }
 
@@ -197,7 +199,7 @@ func (m FormModel) View() string {
 	helpStyle := lipgloss.NewStyle().
 		Foreground(DraculaComment)
 
-	b.WriteString(helpStyle.Render("Tab/↓↑: Navigate • Ctrl+S/Enter: Save • Esc: Cancel"))
+	b.WriteString(helpStyle.Render("Tab/↓↑: Navigate • Ctrl+S/Enter: Save • Ctrl+P: SQL • Ctrl+R: Dry run • Esc: Cancel"))
 
 	return b.String()
 }
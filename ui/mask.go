@@ -0,0 +1,162 @@
+package ui
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/audit"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+	"github.com/qyinm/lazyadmin/mask"
+)
+
+// masksFor returns the mode of each masked column, by lower-cased name,
+// for results read by query. A mask limited to a table applies whenever
+// the query mentions it, so views that join or alias it are masked too.
+// Nothing is masked once revealed, nor is the key of the browsed table,
+// which rows are edited by.
+func (m Model) masksFor(query string) map[string]string {
+	s := m.activeSession()
+	if s == nil || len(s.cfg.Masks) == 0 || m.revealed() {
+		return nil
+	}
+	masks := make(map[string]string)
+	for _, mk := range s.cfg.Masks {
+		if mk.Table != "" && !mentions(query, mk.Table) {
+			continue
+		}
+		if m.currentTable != "" && strings.EqualFold(mk.Column, m.pkColumn) {
+			continue
+		}
+		masks[strings.ToLower(mk.Column)] = mk.Mode
+	}
+	return masks
+}
+
+// mentions reports whether query contains name as a whole word, quoted or
+// not. A match inside a string literal only masks more than needed.
+func mentions(query, name string) bool {
+	return regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(name) + `\b`).MatchString(query)
+}
+
+// maskResult redacts the masked columns of a result read by query and
+// drops the hidden ones. NULLs are left as they are.
+func (m Model) maskResult(query string, cols []table.Column, rows []table.Row) ([]table.Column, []table.Row) {
+	masks := m.masksFor(query)
+	if len(masks) == 0 {
+		return cols, rows
+	}
+
+	modes := make([]string, len(cols))
+	var keep []int
+	var kept []table.Column
+	for i, col := range cols {
+		modes[i] = masks[strings.ToLower(col.Title)]
+		if modes[i] != mask.Hide {
+			keep = append(keep, i)
+			kept = append(kept, col)
+		}
+	}
+
+	masked := make([]table.Row, len(rows))
+	for r, row := range rows {
+		out := make(table.Row, 0, len(keep))
+		for _, i := range keep {
+			v := row[i]
+			if modes[i] != "" && v != "NULL" {
+				v = mask.Value(modes[i], v)
+			}
+			out = append(out, v)
+		}
+		masked[r] = out
+	}
+	return kept, masked
+}
+
+// maskRecord returns a copy of a row of the browsed table with its masked
+// columns redacted, for showing in forms and dialogs. Hidden columns are
+// masked in full there, since the field has to show something.
+func (m Model) maskRecord(record map[string]interface{}) map[string]interface{} {
+	masks := m.masksFor(db.BuildSelectAllQuery(m.driver, m.currentTable, 1))
+	if len(masks) == 0 || record == nil {
+		return record
+	}
+	masked := make(map[string]interface{}, len(record))
+	for col, v := range record {
+		if mode := masks[strings.ToLower(col)]; mode != "" && v != nil {
+			v = mask.Value(mode, toString(v))
+		}
+		masked[col] = v
+	}
+	return masked
+}
+
+// maskArgs returns stmt with the arguments for masked columns of table
+// redacted, for showing it. A WHERE clause can hold values of the row as
+// read, which the user never saw unmasked.
+func (m Model) maskArgs(table string, stmt db.Statement) db.Statement {
+	masks := m.masksFor(db.BuildSelectAllQuery(m.driver, table, 1))
+	if len(masks) == 0 {
+		return stmt
+	}
+	args := slices.Clone(stmt.Args)
+	for i, col := range stmt.Columns {
+		if mode := masks[strings.ToLower(col)]; mode != "" && i < len(args) && args[i] != nil {
+			args[i] = mask.Value(mode, toString(args[i]))
+		}
+	}
+	stmt.Args = args
+	return stmt
+}
+
+// reloadRows runs the query of the rows on screen again, unless it is a
+// view that changes data.
+func (m Model) reloadRows() (tea.Model, tea.Cmd) {
+	switch {
+	case m.mode == ModeTableBrowser && m.currentTable != "":
+		return m.refreshTable()
+	case m.mode == ModeView && m.tableLoaded:
+		if item, ok := m.sidebar.SelectedItem().(ViewItem); ok && !item.isTable && db.IsReadOnlyQuery(item.Query()) {
+			return m.executeQuery(item.Query())
+		}
+	}
+	return m, nil
+}
+
+// revealed reports whether masked columns are shown unmasked: the session
+// has them revealed and the role may still reveal the table browsed.
+func (m Model) revealed() bool {
+	s := m.activeSession()
+	return s != nil && s.revealed && m.allow(config.ActionReveal, m.currentTable) == nil
+}
+
+// toggleMasks reveals the masked columns, if the role allows it, or masks
+// them again, and reloads the rows shown. Revealing is audited.
+func (m Model) toggleMasks() (tea.Model, tea.Cmd) {
+	s := m.activeSession()
+	if s == nil || len(s.cfg.Masks) == 0 {
+		m.statusMsg = "No columns are masked on this connection"
+		return m, nil
+	}
+	if !s.revealed {
+		if err := m.allow(config.ActionReveal, m.currentTable); err != nil {
+			return m.deny(err)
+		}
+		m.audit(audit.Entry{Action: "REVEAL", Table: m.currentTable}, nil)
+	}
+	s.revealed = !s.revealed
+
+	model, cmd := m.reloadRows()
+	if m, ok := model.(Model); ok {
+		if s.revealed {
+			m.statusMsg = "👁 Masked columns revealed; M to mask them again"
+		} else {
+			m.statusMsg = "Masked columns hidden again"
+		}
+		model = m
+	}
+	return model, cmd
+}
@@ -0,0 +1,34 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+func TestMaskArgs(t *testing.T) {
+	m := NewModel(&config.Config{}, "", config.Access{})
+	m.driver = "sqlite"
+	m.currentTable, m.pkColumn = "users", "id"
+	m.sessions = []*session{{cfg: config.DatabaseConfig{Masks: []config.Mask{{Table: "users", Column: "email", Mode: "full"}}}}}
+	m.active = 0
+
+	stmt, err := db.BuildCheckedUpdate("sqlite", "users", "id", 7, map[string]interface{}{"note": "vip"},
+		map[string]interface{}{"email": "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := m.maskArgs("users", stmt)
+	if got := fmt.Sprint(masked.Args); strings.Contains(got, "alice") || !strings.Contains(got, "vip") {
+		t.Errorf("masked args = %s", got)
+	}
+	if stmt.Args[2] != "alice@example.com" {
+		t.Error("maskArgs changed the statement it was given")
+	}
+	if got := fmt.Sprint(m.maskArgs("orders", stmt).Args); !strings.Contains(got, "alice") {
+		t.Errorf("a mask on users applied to orders: %s", got)
+	}
+}
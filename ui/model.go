@@ -4,13 +4,14 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/audit"
 	"github.com/qyinm/lazyadmin/config"
 	"github.com/qyinm/lazyadmin/db"
 )
@@ -39,29 +40,13 @@ const (
 	minConnPaneWidth       = 20
 	minTablePaneWidth      = 25
 	horizontalPaddingTotal = 4
-	verticalPaddingTotal   = 6
+	verticalPaddingTotal   = 7
 )
 
-type formFieldInfo struct {
-	label       string
-	placeholder string
-	isPassword  bool
-}
-
-var connFormFieldInfos = []formFieldInfo{
-	{label: "Label"},
-	{label: "Driver"},
-	{label: "Host"},
-	{label: "Port", placeholder: "5432"},
-	{label: "User"},
-	{label: "Password", isPassword: true},
-	{label: "Database Name"},
-	{label: "Path (SQLite)"},
-}
-
 type Model struct {
 	config        *config.Config
 	configPath    string
+	access        config.Access
 	db            *sql.DB
 	driver        string
 	sidebar       list.Model
@@ -79,14 +64,58 @@ type Model struct {
 	form          FormModel
 	showForm      bool
 	confirmMsg    string
-	confirmAction func()
+	confirmStmt   *db.Statement // the write a confirm dialog is asking about
+	confirmTable  string        // its table, if not the current one
+	confirmAction func(m *Model) tea.Cmd
+	confirmReturn Focus
+	// confirmExpect, when set, has to be typed into confirmInput to confirm
+	// instead of pressing y.
+	confirmExpect string
+	confirmInput  textinput.Model
 	tables        []db.TableInfo
 
 	connSidebar list.Model
 	connForm    []textinput.Model
+	connLabel   string
+	editingConn string // label of the connection the form edits, "" for a new one
+	connStates  map[string]connState
+	pendingConn string
+
+	sessions    []*session
+	active      int
+	showStatus  bool
+	showPending bool
+
+	// resultCols and resultRows are the loaded rows before the filter and
+	// selection marks are applied; selected holds the primary keys of the
+	// selected rows and anchor the last one toggled.
+	resultCols  []table.Column
+	resultRows  []table.Row
+	filter      string
+	filterInput textinput.Model
+	filtering   bool
+	selected    map[string]bool
+	anchor      string
+	batchInput  textinput.Model
+	batchSet    bool
+
+	// showSQL and dryRunResult belong to the open form or confirm dialog.
+	showSQL      bool
+	dryRunResult string
+
+	connTesting   bool
+	connTestSteps []db.DiagnosticStep
+
+	authRequests chan authRequest
+	authPrompt   *authPrompt
+
+	// auditErr is the last failure to write an audit entry.
+	auditErr error
+
+	configStamps map[string]fileStamp
 }
 
-func NewModel(cfg *config.Config, configPath string, database *sql.DB) Model {
+func NewModel(cfg *config.Config, configPath string, access config.Access) Model {
 	t := table.New(
 		table.WithColumns([]table.Column{}),
 		table.WithRows([]table.Row{}),
@@ -108,15 +137,7 @@ func NewModel(cfg *config.Config, configPath string, database *sql.DB) Model {
 	s.Cell = lipgloss.NewStyle()
 	t.SetStyles(s)
 
-	var connItems []list.Item
-	for _, c := range cfg.Connections {
-		connItems = append(connItems, ViewItem{
-			title:       c.Label,
-			description: fmt.Sprintf("%s (%s)", c.Driver, c.Host),
-			query:       c.Name,
-		})
-	}
-	connList := list.New(connItems, list.NewDefaultDelegate(), 0, 0)
+	connList := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
 	connList.Title = "Connections"
 	connList.SetShowHelp(false)
 	connList.SetShowStatusBar(false)
@@ -139,44 +160,49 @@ func NewModel(cfg *config.Config, configPath string, database *sql.DB) Model {
 	tableList.SetFilteringEnabled(false)
 	tableList.Styles.Title = TitleStyle
 
-	inputs := make([]textinput.Model, len(connFormFieldInfos))
-	for i, info := range connFormFieldInfos {
-		ti := textinput.New()
-		ti.Cursor.Style = lipgloss.NewStyle().Foreground(DraculaPink)
-		ti.Prompt = info.label + ": "
-		ti.PromptStyle = lipgloss.NewStyle().Foreground(DraculaCyan)
-		ti.Placeholder = info.placeholder
-
-		if info.isPassword {
-			ti.EchoMode = textinput.EchoPassword
-			ti.EchoCharacter = '•'
-		}
-
-		inputs[i] = ti
-	}
-
-	mode := ModeView
-	startFocus := FocusConnections
-	if database != nil {
-		startFocus = FocusSidebar
-	}
-
-	return Model{
+	m := Model{
 		config:      cfg,
 		configPath:  configPath,
-		db:          database,
-		driver:      cfg.Database.Driver,
+		access:      access,
 		sidebar:     tableList,
 		table:       t,
-		focus:       startFocus,
-		mode:        mode,
+		focus:       FocusConnections,
+		mode:        ModeView,
 		tableLoaded: false,
 		connSidebar: connList,
-		connForm:    inputs,
+		connForm:    newConnFormInputs(),
+		connStates:  make(map[string]connState),
+		active:      -1,
+
+		authRequests: make(chan authRequest),
+	}
+	if i := connectionIndex(cfg, cfg.Default); i >= 0 && access.Connection(cfg.Default) == nil {
+		// Init starts connecting to the default connection.
+		m.pendingConn = cfg.Default
+		m.connStates[cfg.Default] = connState{status: connConnecting}
+		m.connSidebar.Select(i)
 	}
+	m.refreshConnectionList()
+	m.refreshSidebarList()
+	m.snapshotConfig()
+	return m
 }
 
 func (m Model) Init() tea.Cmd {
+	cmds := []tea.Cmd{watchConfig(), waitAuthRequest(m.authRequests)}
+	if i := connectionIndex(m.config, m.pendingConn); i >= 0 {
+		cmds = append(cmds, connectCmd(m.config.Connections[i], newPrompter(m.authRequests)))
+	}
+	return tea.Batch(cmds...)
+}
+
+// Close disconnects every open session. It is meant to be called on the
+// final model once the program has exited.
+func (m Model) Close() error {
+	for _, s := range m.sessions {
+		rollbackSession(s)
+		s.conn.Close()
+	}
 	return nil
 }
 
@@ -189,6 +215,28 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	// Background results must be handled whatever pane has focus.
+	switch msg := msg.(type) {
+	case configTickMsg:
+		return m.handleConfigTick()
+	case connTestMsg:
+		m.connTesting = false
+		m.connTestSteps = msg.steps
+		return m, nil
+	case connectedMsg:
+		return m.handleConnected(msg)
+	case tunnelEventMsg:
+		return m.handleTunnelEvent(msg)
+	case authRequestMsg:
+		return m.showAuthPrompt(msg.req)
+	case conflictMsg:
+		return m.showConflict(msg)
+	}
+
+	if m.authPrompt != nil {
+		return m.updateAuthPrompt(msg)
+	}
+
 	if m.mode == ModeConnectionForm {
 		return m.updateConnectionForm(msg)
 	}
@@ -201,6 +249,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateConfirm(msg)
 	}
 
+	if m.filtering {
+		return m.updateFilter(msg)
+	}
+
+	if m.batchSet {
+		return m.updateBatchSet(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -230,26 +286,102 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case "n":
 			if m.focus == FocusConnections {
-				m.mode = ModeConnectionForm
-				m.connForm[0].Focus()
-				return m, textinput.Blink
+				return m.showConnectionForm(-1)
+			}
+
+		case "c":
+			if m.focus == FocusConnections {
+				return m.duplicateConnection()
+			}
+
+		case "K", "shift+up":
+			if m.focus == FocusConnections {
+				return m.moveConnection(-1)
+			}
+
+		case "J", "shift+down":
+			if m.focus == FocusConnections {
+				return m.moveConnection(1)
 			}
 
 		case "i":
 			if m.focus == FocusTable && m.currentTable != "" && m.mode == ModeTableBrowser {
+				if m.readOnly() {
+					return m.refuseWrite()
+				}
+				if err := m.allow(config.ActionInsert, m.currentTable); err != nil {
+					return m.deny(err)
+				}
 				return m.showInsertForm()
 			}
 
 		case "e":
+			if m.focus == FocusConnections && len(m.config.Connections) > 0 {
+				return m.showConnectionForm(m.connSidebar.Index())
+			}
 			if m.focus == FocusTable && m.currentTable != "" && m.mode == ModeTableBrowser && m.tableLoaded {
+				if m.readOnly() {
+					return m.refuseWrite()
+				}
+				if err := m.allow(config.ActionUpdate, m.currentTable); err != nil {
+					return m.deny(err)
+				}
 				return m.showEditForm()
 			}
 
 		case "d":
+			if m.focus == FocusConnections && len(m.config.Connections) > 0 {
+				return m.showDeleteConnectionConfirm()
+			}
 			if m.focus == FocusTable && m.currentTable != "" && m.mode == ModeTableBrowser && m.tableLoaded {
+				if m.readOnly() {
+					return m.refuseWrite()
+				}
+				if err := m.allow(config.ActionDelete, m.currentTable); err != nil {
+					return m.deny(err)
+				}
+				if len(m.selected) > 0 {
+					return m.showBatchDeleteConfirm()
+				}
 				return m.showDeleteConfirm()
 			}
 
+		case " ", "space":
+			if m.focus == FocusTable {
+				return m.toggleSelection()
+			}
+
+		case "V":
+			if m.focus == FocusTable {
+				return m.selectRange()
+			}
+
+		case "A":
+			if m.focus == FocusTable {
+				return m.selectAllMatching()
+			}
+
+		case "U":
+			if m.focus == FocusTable && len(m.selected) > 0 {
+				if m.readOnly() {
+					return m.refuseWrite()
+				}
+				if err := m.allow(config.ActionUpdate, m.currentTable); err != nil {
+					return m.deny(err)
+				}
+				return m.startBatchSet()
+			}
+
+		case "/":
+			if m.focus == FocusTable {
+				return m.startFilter()
+			}
+
+		case "esc":
+			if m.focus == FocusTable {
+				return m.clearSelection()
+			}
+
 		case "r":
 			if m.currentTable != "" {
 				return m.refreshTable()
@@ -258,8 +390,40 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "t":
 			return m.toggleMode()
 
+		case "[":
+			return m.switchSession(m.active - 1)
+
+		case "]":
+			return m.switchSession(m.active + 1)
+
+		case "x":
+			return m.disconnectActive()
+
+		case "s":
+			m.showStatus = !m.showStatus
+			return m, nil
+
+		case "T":
+			return m.beginTransaction()
+
+		case "C":
+			return m.showEndTransactionConfirm(true)
+
+		case "R":
+			return m.showEndTransactionConfirm(false)
+
+		case "P":
+			m.showPending = !m.showPending
+			return m, nil
+
+		case "u":
+			return m.showUndoConfirm()
+
+		case "M":
+			return m.toggleMasks()
+
 		case "?":
-			m.statusMsg = "Tab: Cycle Focus • Enter: Select • i/e/d: CRUD • n: New Conn"
+			m.statusMsg = "Tab: Cycle Focus • Enter: Select • i/e/d: CRUD • n/e/c/d/J/K: Manage Conns • [/]: Switch Tab • x: Disconnect • s: Status • T/C/R: Begin/Commit/Roll back • P: Pending • u: Undo • Space/V/A: Select • /: Filter • M: Reveal masked"
 			return m, nil
 		}
 
@@ -308,7 +472,7 @@ func (m *Model) resizePanes() {
 		contentHeight = 0
 	}
 
-	m.connSidebar.SetSize(connWidth-horizontalPaddingTotal, contentHeight-1)
+	m.connSidebar.SetSize(connWidth-horizontalPaddingTotal, contentHeight-3)
 	m.sidebar.SetSize(tableWidth-horizontalPaddingTotal, contentHeight)
 
 	m.table.SetWidth(contentWidth - horizontalPaddingTotal)
@@ -317,36 +481,18 @@ func (m *Model) resizePanes() {
 
 func (m Model) handleConnectionSelect() (tea.Model, tea.Cmd) {
 	index := m.connSidebar.Index()
-	if index >= 0 && index < len(m.config.Connections) {
-		connConfig := m.config.Connections[index]
-		conn, err := db.Connect(&connConfig)
-		if err != nil {
-			m.err = err
-			return m, nil
-		}
-
-		if m.db != nil {
-			m.db.Close()
-		}
-
-		m.db = conn.DB
-		m.driver = connConfig.Driver
-		m.tables = nil
-		m.currentTable = ""
-		m.statusMsg = fmt.Sprintf("Connected to %s", connConfig.Label)
-
-		tables, err := db.GetTables(m.db, m.driver)
-		if err == nil {
-			m.tables = tables
-			m.mode = ModeTableBrowser
-			m.refreshSidebarList()
-		} else {
-			m.err = err
-		}
-
+	if index < 0 || index >= len(m.config.Connections) {
+		return m, nil
+	}
+	cfg := m.config.Connections[index]
+	if err := m.access.Connection(cfg.Label); err != nil {
+		return m.deny(err)
+	}
+	if i := m.sessionIndex(cfg.Label); i >= 0 {
 		m.focus = FocusSidebar
+		return m.switchSession(i)
 	}
-	return m, nil
+	return m.connect(cfg)
 }
 
 func (m *Model) refreshSidebarList() {
@@ -373,116 +519,6 @@ func (m *Model) refreshSidebarList() {
 	m.sidebar.SetItems(items)
 }
 
-func (m Model) updateConnectionForm(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "esc":
-			m.mode = ModeView
-			return m, nil
-		case "tab", "enter":
-			focusedIndex := -1
-			for i := range m.connForm {
-				if m.connForm[i].Focused() {
-					focusedIndex = i
-					break
-				}
-			}
-
-			if msg.String() == "enter" && focusedIndex == len(m.connForm)-1 {
-				return m.submitConnectionForm()
-			}
-
-			if focusedIndex >= 0 {
-				m.connForm[focusedIndex].Blur()
-				next := (focusedIndex + 1) % len(m.connForm)
-				m.connForm[next].Focus()
-			}
-			return m, nil
-		}
-	}
-
-	cmds := make([]tea.Cmd, len(m.connForm))
-	for i := range m.connForm {
-		m.connForm[i], cmds[i] = m.connForm[i].Update(msg)
-	}
-	return m, tea.Batch(cmds...)
-}
-
-func (m Model) getConnFormFieldValue(label string) string {
-	for i, info := range connFormFieldInfos {
-		if info.label == label {
-			return m.connForm[i].Value()
-		}
-	}
-	return ""
-}
-
-func (m Model) submitConnectionForm() (tea.Model, tea.Cmd) {
-	portStr := m.getConnFormFieldValue("Port")
-	var port int
-	if portStr != "" {
-		var err error
-		port, err = strconv.Atoi(portStr)
-		if err != nil {
-			m.err = fmt.Errorf("invalid port number: %s", portStr)
-			return m, nil
-		}
-	}
-
-	newConn := config.DatabaseConfig{
-		Label:    m.getConnFormFieldValue("Label"),
-		Driver:   m.getConnFormFieldValue("Driver"),
-		Host:     m.getConnFormFieldValue("Host"),
-		Port:     port,
-		User:     m.getConnFormFieldValue("User"),
-		Password: m.getConnFormFieldValue("Password"),
-		Name:     m.getConnFormFieldValue("Database Name"),
-		Path:     m.getConnFormFieldValue("Path (SQLite)"),
-	}
-
-	switch newConn.Driver {
-	case "sqlite", "sqlite3", "postgres", "postgresql", "mysql":
-	case "":
-		m.err = fmt.Errorf("driver is required")
-		return m, nil
-	default:
-		m.err = fmt.Errorf("unsupported driver: %s", newConn.Driver)
-		return m, nil
-	}
-
-	if newConn.Label == "" {
-		m.err = fmt.Errorf("label is required")
-		return m, nil
-	}
-
-	m.config.Connections = append(m.config.Connections, newConn)
-
-	if err := config.Save(m.configPath, m.config); err != nil {
-		m.err = err
-		return m, nil
-	}
-
-	var items []list.Item
-	for _, c := range m.config.Connections {
-		items = append(items, ViewItem{
-			title:       c.Label,
-			description: fmt.Sprintf("%s (%s)", c.Driver, c.Host),
-			query:       c.Name,
-		})
-	}
-	m.connSidebar.SetItems(items)
-
-	m.mode = ModeView
-	m.statusMsg = "Connection added"
-
-	for i := range m.connForm {
-		m.connForm[i].SetValue("")
-	}
-
-	return m, nil
-}
-
 func (m Model) handleSidebarSelect() (tea.Model, tea.Cmd) {
 	item, ok := m.sidebar.SelectedItem().(ViewItem)
 	if !ok {
@@ -492,7 +528,12 @@ func (m Model) handleSidebarSelect() (tea.Model, tea.Cmd) {
 	if item.isTable {
 		validTable := false
 		if m.tables == nil {
-			tables, err := db.GetTables(m.db, m.driver)
+			var tables []db.TableInfo
+			err := m.read(func(q db.Querier) error {
+				var err error
+				tables, err = db.GetTables(q, m.driver)
+				return err
+			})
 			if err == nil {
 				m.tables = tables
 			}
@@ -509,18 +550,32 @@ func (m Model) handleSidebarSelect() (tea.Model, tea.Cmd) {
 			m.err = fmt.Errorf("invalid table name: %s", item.query)
 			return m, nil
 		}
+		if err := m.allow(config.ActionRead, item.query); err != nil {
+			return m.deny(err)
+		}
 
 		m.currentTable = item.query
 		m.mode = ModeTableBrowser
+		m.resetRows()
 
-		columns, err := db.GetColumns(m.db, m.driver, m.currentTable)
+		var columns []db.ColumnInfo
+		err := m.read(func(q db.Querier) error {
+			var err error
+			columns, err = db.GetColumns(q, m.driver, m.currentTable)
+			return err
+		})
 		if err != nil {
 			m.err = err
 			return m, nil
 		}
 		m.columns = columns
 
-		pkCol, err := db.GetPrimaryKey(m.db, m.driver, m.currentTable)
+		var pkCol string
+		err = m.read(func(q db.Querier) error {
+			var err error
+			pkCol, err = db.GetPrimaryKey(q, m.driver, m.currentTable)
+			return err
+		})
 		if err != nil {
 			if errors.Is(err, db.ErrNoPrimaryKey) {
 				m.statusMsg = fmt.Sprintf("Warning: %s has no primary key", m.currentTable)
@@ -535,6 +590,10 @@ func (m Model) handleSidebarSelect() (tea.Model, tea.Cmd) {
 		return m.executeQuery(query)
 	}
 
+	if err := m.access.View(item.title); err != nil {
+		return m.deny(err)
+	}
+	m.resetRows()
 	return m.executeQuery(item.Query())
 }
 
@@ -543,15 +602,64 @@ func (m Model) executeQuery(query string) (tea.Model, tea.Cmd) {
 		m.err = fmt.Errorf("no database connection")
 		return m, nil
 	}
-	cols, rows, err := db.RunQuery(m.db, query)
+	if m.readOnly() && !db.IsReadOnlyQuery(query) {
+		m.err = fmt.Errorf("%s: %w; only single SELECT-style queries can run", m.connLabel, db.ErrReadOnly)
+		return m, nil
+	}
+	if m.production() && !db.IsReadOnlyQuery(query) {
+		return m.showSQLConfirm(query)
+	}
+	return m.runQuery(query)
+}
+
+// showSQLConfirm asks before running query, which may change data on a
+// production connection, as for any other production write.
+func (m Model) showSQLConfirm(query string) (tea.Model, tea.Cmd) {
+	if err := m.allow(config.ActionSQL, ""); err != nil {
+		return m.deny(err)
+	}
+	stmt := db.Statement{SQL: query}
+	m.confirmMsg = m.productionPreview("Run this SQL?", stmt) +
+		fmt.Sprintf("\n\nType %q to confirm, or esc to cancel.", m.connLabel)
+	m.confirmStmt = &stmt
+	m.confirmReturn = m.focus
+	m.confirmAction = func(m *Model) tea.Cmd {
+		next, cmd := m.runQuery(query)
+		*m = next.(Model)
+		return cmd
+	}
+	m.focus = FocusConfirm
+	return m, m.expectTyped(m.connLabel)
+}
+
+// runQuery runs query and shows its result.
+func (m Model) runQuery(query string) (tea.Model, tea.Cmd) {
+	var cols []table.Column
+	var rows []table.Row
+	var err error
+	if db.IsReadOnlyQuery(query) {
+		err = m.read(func(q db.Querier) error {
+			cols, rows, err = db.RunQuery(q, query)
+			return err
+		})
+	} else if err = m.allow(config.ActionSQL, ""); err == nil {
+		err = m.write(func(q db.Querier) error {
+			cols, rows, err = db.RunQuery(q, query)
+			return err
+		})
+		m.audit(audit.Entry{Action: "SQL", SQL: query}, err)
+		if err == nil {
+			m.stage("SQL", "", "", "", db.Statement{SQL: query})
+		}
+	}
 	if err != nil {
 		m.err = err
 		return m, nil
 	}
+	cols, rows = m.maskResult(query, cols, rows)
+	cols, rows = m.markPending(cols, rows)
 
-	m.table.SetRows([]table.Row{})
-	m.table.SetColumns(cols)
-	m.table.SetRows(rows)
+	m.setResult(cols, rows)
 	if len(rows) > 0 {
 		m.table.SetCursor(0)
 	}
@@ -562,6 +670,17 @@ func (m Model) executeQuery(query string) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// readOnly reports whether the active connection is configured read-only.
+func (m Model) readOnly() bool {
+	s := m.activeSession()
+	return s != nil && s.cfg.ReadOnly
+}
+
+func (m Model) refuseWrite() (tea.Model, tea.Cmd) {
+	m.err = fmt.Errorf("%s: %w", m.connLabel, db.ErrReadOnly)
+	return m, nil
+}
+
 func (m Model) refreshTable() (tea.Model, tea.Cmd) {
 	if m.currentTable == "" {
 		return m, nil
@@ -572,9 +691,19 @@ func (m Model) refreshTable() (tea.Model, tea.Cmd) {
 }
 
 func (m Model) toggleMode() (tea.Model, tea.Cmd) {
+	if m.db == nil {
+		m.err = fmt.Errorf("no database connection")
+		return m, nil
+	}
+
 	if m.mode == ModeView {
 		m.mode = ModeTableBrowser
-		tables, err := db.GetTables(m.db, m.driver)
+		var tables []db.TableInfo
+		err := m.read(func(q db.Querier) error {
+			var err error
+			tables, err = db.GetTables(q, m.driver)
+			return err
+		})
 		if err == nil {
 			m.tables = tables
 		}
@@ -587,12 +716,14 @@ func (m Model) toggleMode() (tea.Model, tea.Cmd) {
 
 	m.refreshSidebarList()
 	m.tableLoaded = false
+	m.resetRows()
 
 	return m, nil
 }
 
 func (m Model) showInsertForm() (tea.Model, tea.Cmd) {
 	m.form = NewFormModel(m.columns, FormModeInsert, m.currentTable, m.pkColumn, nil, nil)
+	m.resetPreview()
 	m.showForm = true
 	m.focus = FocusForm
 	return m, m.form.Init()
@@ -624,13 +755,21 @@ func (m Model) showEditForm() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
-	record, err := db.GetRecordByPK(m.db, m.driver, m.currentTable, m.pkColumn, pkValue)
+	var record map[string]interface{}
+	err := m.read(func(q db.Querier) error {
+		var err error
+		record, err = db.GetRecordByPK(q, m.driver, m.currentTable, m.pkColumn, pkValue)
+		return err
+	})
 	if err != nil {
 		m.err = err
 		return m, nil
 	}
 
-	m.form = NewFormModel(m.columns, FormModeEdit, m.currentTable, m.pkColumn, pkValue, record)
+	// Masked values show masked and, left alone, aren't written back.
+	m.form = NewFormModel(m.columns, FormModeEdit, m.currentTable, m.pkColumn, pkValue, m.maskRecord(record))
+	m.form.original = record
+	m.resetPreview()
 	m.showForm = true
 	m.focus = FocusForm
 	return m, m.form.Init()
@@ -657,22 +796,107 @@ func (m Model) showDeleteConfirm() (tea.Model, tea.Cmd) {
 		}
 	}
 
+	stmt := db.BuildDelete(m.driver, m.currentTable, m.pkColumn, pkValue)
 	m.confirmMsg = fmt.Sprintf("Delete record with %s = %v? (y/n)", m.pkColumn, pkValue)
-	m.confirmAction = func() {
-		err := db.DeleteRecord(m.db, m.driver, m.currentTable, m.pkColumn, pkValue)
+	m.confirmStmt = &stmt
+	var cmd tea.Cmd
+	if m.production() {
+		m.confirmMsg = m.productionPreview(fmt.Sprintf("Delete record with %s = %v?", m.pkColumn, pkValue), stmt) +
+			fmt.Sprintf("\n\nType %q to confirm, or esc to cancel.", m.currentTable)
+		cmd = m.expectTyped(m.currentTable)
+	}
+	m.confirmReturn = FocusTable
+	m.confirmAction = func(m *Model) tea.Cmd {
+		err := m.deleteRecord(m.currentTable, m.pkColumn, pkValue)
 		if err != nil {
 			m.err = err
 			m.statusMsg = "Delete failed: " + err.Error()
 		} else {
-			m.statusMsg = "Record deleted successfully"
+			m.statusMsg = "Record deleted successfully" + m.stagedSuffix()
 		}
+		return nil
 	}
 	m.focus = FocusConfirm
 
+	return m, cmd
+}
+
+// showUpdateConfirm asks before saving an edit on a production connection,
+// showing the UPDATE that will run and how many rows it will touch.
+func (m Model) showUpdateConfirm(data map[string]interface{}) (tea.Model, tea.Cmd) {
+	table, pkColumn, pkValue, original := m.currentTable, m.pkColumn, m.form.pkValue, m.form.original
+	stmt, err := db.BuildCheckedUpdate(m.driver, table, pkColumn, pkValue, data, m.expected(original, data))
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	m.showForm = false
+	m.confirmMsg = m.productionPreview(fmt.Sprintf("Update record with %s = %v?", pkColumn, pkValue), stmt) + "\n\n(y/n)"
+	m.confirmStmt = &stmt
+	m.confirmReturn = FocusTable
+	m.confirmAction = func(m *Model) tea.Cmd {
+		return m.saveEdit(table, pkColumn, pkValue, original, data)
+	}
+	m.focus = FocusConfirm
 	return m, nil
 }
 
+// productionPreview describes a write about to run on a production
+// connection: the statement, or the first of several run one per row, and
+// an estimate of the rows they affect.
+func (m Model) productionPreview(question string, stmts ...db.Statement) string {
+	var total int64
+	estimate := ""
+	for _, stmt := range stmts {
+		var n int64
+		err := m.read(func(q db.Querier) error {
+			var err error
+			n, err = stmt.EstimateRows(q)
+			return err
+		})
+		if errors.Is(err, db.ErrNoEstimate) {
+			estimate = "unavailable"
+			break
+		} else if err != nil {
+			estimate = "unknown (" + err.Error() + ")"
+			break
+		}
+		total += n
+	}
+	if estimate == "" {
+		estimate = fmt.Sprint(total)
+	}
+
+	stmt := stmts[0]
+	shown := "  " + stmt.SQL
+	if len(stmt.Args) > 0 {
+		shown += fmt.Sprintf("\n  args: %v", m.maskArgs(m.previewTable(), stmt).Args)
+	}
+	if len(stmts) > 1 {
+		shown += fmt.Sprintf("\n  … and %d more like it, one per row", len(stmts)-1)
+	}
+	return fmt.Sprintf("⚠ PRODUCTION: %s\n\n%s\n\n%s\n\nRows affected (estimate): %s",
+		m.connLabel, question, shown, estimate)
+}
+
+// expectTyped makes the open confirmation require typing want.
+func (m *Model) expectTyped(want string) tea.Cmd {
+	ti := textinput.New()
+	ti.Prompt = "> "
+	ti.PromptStyle = lipgloss.NewStyle().Foreground(DraculaCyan)
+	ti.CharLimit = 256
+	ti.Width = 40
+	m.confirmExpect = want
+	m.confirmInput = ti
+	return m.confirmInput.Focus()
+}
+
 func (m Model) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if next, ok := m.handlePreviewKey(msg, m.formStatement); ok {
+		return next, nil
+	}
+
 	var cmd tea.Cmd
 	m.form, cmd = m.form.Update(msg)
 
@@ -694,24 +918,21 @@ func (m Model) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			if len(data) > 0 {
-				err := db.InsertRecord(m.db, m.driver, m.currentTable, data)
+				err := m.insertRecord(data)
 				if err != nil {
 					m.err = err
 					m.statusMsg = "Insert failed: " + err.Error()
 				} else {
-					m.statusMsg = "Record inserted successfully"
+					m.statusMsg = "Record inserted successfully" + m.stagedSuffix()
 				}
 			}
 		} else {
 			data := m.form.GetChangedData()
+			if len(data) > 0 && m.production() {
+				return m.showUpdateConfirm(data)
+			}
 			if len(data) > 0 {
-				err := db.UpdateRecord(m.db, m.driver, m.currentTable, m.pkColumn, m.form.pkValue, data)
-				if err != nil {
-					m.err = err
-					m.statusMsg = "Update failed: " + err.Error()
-				} else {
-					m.statusMsg = "Record updated successfully"
-				}
+				cmd = m.saveEdit(m.currentTable, m.pkColumn, m.form.pkValue, m.form.original, data)
 			} else {
 				m.statusMsg = "No changes made"
 			}
@@ -720,41 +941,100 @@ func (m Model) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.showForm = false
 		m.focus = FocusTable
 
-		return m.refreshTable()
+		refreshed, refreshCmd := m.refreshTable()
+		return refreshed, tea.Batch(cmd, refreshCmd)
 	}
 
 	return m, cmd
 }
 
 func (m Model) updateConfirm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if m.confirmStmt != nil {
+		stmt := *m.confirmStmt
+		if next, ok := m.handlePreviewKey(msg, func() (db.Statement, error) { return stmt, nil }); ok {
+			return next, nil
+		}
+	}
+
+	if m.confirmExpect != "" {
+		if key, ok := msg.(tea.KeyMsg); ok {
+			switch key.String() {
+			case "enter":
+				if strings.TrimSpace(m.confirmInput.Value()) != m.confirmExpect {
+					m.statusMsg = fmt.Sprintf("Type %q exactly to confirm", m.confirmExpect)
+					return m, nil
+				}
+				return m.acceptConfirm()
+			case "esc":
+				return m.cancelConfirm()
+			}
+		}
+		var cmd tea.Cmd
+		m.confirmInput, cmd = m.confirmInput.Update(msg)
+		return m, cmd
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "y", "Y":
-			if m.confirmAction != nil {
-				m.confirmAction()
-			}
-			m.focus = FocusTable
-			m.confirmMsg = ""
-			m.confirmAction = nil
-			return m.refreshTable()
-
+			return m.acceptConfirm()
 		case "n", "N", "esc":
-			m.focus = FocusTable
-			m.confirmMsg = ""
-			m.confirmAction = nil
-			m.statusMsg = "Cancelled"
-			return m, nil
+			return m.cancelConfirm()
 		}
 	}
 	return m, nil
 }
 
+func (m Model) acceptConfirm() (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if m.confirmAction != nil {
+		cmd = m.confirmAction(&m)
+	}
+	m.focus = m.confirmReturn
+	m.clearConfirm()
+	if m.focus != FocusTable {
+		return m, cmd
+	}
+	refreshed, refreshCmd := m.refreshTable()
+	// The status bar reports what was confirmed, not the reload after it,
+	// unless the reload failed.
+	if next, ok := refreshed.(Model); ok && next.err == nil {
+		next.err, next.statusMsg = m.err, m.statusMsg
+		refreshed = next
+	}
+	return refreshed, tea.Batch(cmd, refreshCmd)
+}
+
+func (m Model) cancelConfirm() (tea.Model, tea.Cmd) {
+	m.focus = m.confirmReturn
+	m.clearConfirm()
+	m.statusMsg = "Cancelled"
+	return m, nil
+}
+
+func (m *Model) clearConfirm() {
+	m.confirmMsg = ""
+	m.confirmStmt = nil
+	m.confirmTable = ""
+	m.confirmAction = nil
+	m.confirmExpect = ""
+	m.resetPreview()
+}
+
 func (m Model) View() string {
 	if m.width == 0 {
 		return "Loading..."
 	}
 
+	if m.authPrompt != nil {
+		return AppStyle.Width(m.width).Height(m.height).Render(
+			lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+				m.viewAuthPrompt(),
+			),
+		)
+	}
+
 	if m.mode == ModeConnectionForm {
 		return AppStyle.Width(m.width).Height(m.height).Render(
 			lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
@@ -790,29 +1070,38 @@ func (m Model) View() string {
 	availableHeight := m.height - verticalPaddingTotal
 
 	connHelp := lipgloss.NewStyle().Render(
-		fmt.Sprintf("%s %s",
+		fmt.Sprintf("%s %s %s %s\n%s %s %s %s\n%s %s",
 			HelpKeyStyle.Render("n"),
-			HelpDescStyle.Render("new conn"),
+			HelpDescStyle.Render("new"),
+			HelpKeyStyle.Render("e"),
+			HelpDescStyle.Render("edit"),
+			HelpKeyStyle.Render("c"),
+			HelpDescStyle.Render("copy"),
+			HelpKeyStyle.Render("d"),
+			HelpDescStyle.Render("del"),
+			HelpKeyStyle.Render("J/K"),
+			HelpDescStyle.Render("move"),
 		),
 	)
 	connContent := lipgloss.JoinVertical(lipgloss.Left, m.connSidebar.View(), connHelp)
 
 	if m.focus == FocusConnections {
-		connBox = SidebarActiveStyle.Width(connWidth).MaxWidth(connWidth).Height(availableHeight).MaxHeight(availableHeight).Render(connContent)
+		connBox = m.tint(SidebarActiveStyle).Width(connWidth).MaxWidth(connWidth).Height(availableHeight).MaxHeight(availableHeight).Render(connContent)
 	} else {
-		connBox = SidebarStyle.Width(connWidth).MaxWidth(connWidth).Height(availableHeight).MaxHeight(availableHeight).Render(connContent)
+		connBox = m.tint(SidebarStyle).Width(connWidth).MaxWidth(connWidth).Height(availableHeight).MaxHeight(availableHeight).Render(connContent)
 	}
 
 	if m.focus == FocusSidebar {
-		sidebarBox = SidebarActiveStyle.Width(tableWidth).MaxWidth(tableWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.sidebar.View())
+		sidebarBox = m.tint(SidebarActiveStyle).Width(tableWidth).MaxWidth(tableWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.sidebar.View())
 	} else {
-		sidebarBox = SidebarStyle.Width(tableWidth).MaxWidth(tableWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.sidebar.View())
+		sidebarBox = m.tint(SidebarStyle).Width(tableWidth).MaxWidth(tableWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.sidebar.View())
 	}
 
 	cStyle := ContentStyle
 	if m.focus == FocusTable || m.focus == FocusForm || m.focus == FocusConfirm {
 		cStyle = ContentActiveStyle
 	}
+	cStyle = m.tint(cStyle)
 
 	if m.focus == FocusConfirm {
 		contentBox = cStyle.Width(contentWidth).MaxWidth(contentWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.renderConfirm())
@@ -820,7 +1109,10 @@ func (m Model) View() string {
 		contentBox = cStyle.Width(contentWidth).MaxWidth(contentWidth).Height(availableHeight).MaxHeight(availableHeight).Render(m.renderContent())
 	}
 
-	mainView := lipgloss.JoinHorizontal(lipgloss.Top, connBox, sidebarBox, contentBox)
+	mainView := lipgloss.JoinVertical(lipgloss.Left,
+		m.renderTabBar(),
+		lipgloss.JoinHorizontal(lipgloss.Top, connBox, sidebarBox, contentBox),
+	)
 
 	statusStyle := lipgloss.NewStyle().
 		Foreground(DraculaComment).
@@ -831,6 +1123,12 @@ func (m Model) View() string {
 		modeIndicator = "[Tables]"
 	}
 	status := fmt.Sprintf("%s %s | %s | ?: Help", modeIndicator, m.currentTable, m.statusMsg)
+	if m.revealed() {
+		status += " | 👁 unmasked"
+	}
+	if m.auditErr != nil {
+		status += fmt.Sprintf(" | ⚠ audit: %v", m.auditErr)
+	}
 	if m.err != nil {
 		status = fmt.Sprintf("❌ %s", m.err.Error())
 	}
@@ -839,28 +1137,28 @@ func (m Model) View() string {
 	return AppStyle.Width(m.width).Height(m.height).Render(finalView)
 }
 
-func (m Model) viewConnectionForm() string {
-	var b string
-	b += TitleStyle.Render("New Connection") + "\n\n"
-
-	for i := range m.connForm {
-		b += m.connForm[i].View() + "\n"
-	}
-
-	b += "\n" + EmptyStateStyle.Render("Enter: Save • Tab: Next • Esc: Cancel")
-	return b
-}
-
 func (m Model) renderContent() string {
+	if m.showStatus {
+		return m.renderConnectionStatus()
+	}
+	if m.showPending {
+		return m.renderPending()
+	}
 	if m.err != nil {
 		return EmptyStateStyle.Render("Error: " + m.err.Error())
 	}
 	if !m.tableLoaded {
 		if m.mode == ModeTableBrowser {
-			return EmptyStateStyle.Render("Select a table to browse data\n\ni: Insert  e: Edit  d: Delete  r: Refresh")
+			if m.readOnly() {
+				return EmptyStateStyle.Render("Select a table to browse data\n\n🔒 Read-only  r: Refresh")
+			}
+			return EmptyStateStyle.Render("Select a table to browse data\n\ni: Insert  e: Edit  d: Delete  r: Refresh  T: Transaction")
 		}
 		return EmptyStateStyle.Render("Select a menu item to view data")
 	}
+	if bar := m.renderSelectionBar(); bar != "" {
+		return bar + "\n" + m.table.View()
+	}
 	return m.table.View()
 }
 
@@ -871,9 +1169,21 @@ func (m Model) renderConfirm() string {
 		Bold(true).
 		Padding(2)
 
-	return confirmStyle.Render(m.confirmMsg)
+	msg := m.confirmMsg
+	if m.confirmStmt != nil {
+		msg += m.renderPreview(*m.confirmStmt, nil) + "\n\nCtrl+P: SQL  Ctrl+R: Dry run"
+	}
+	if m.confirmExpect != "" {
+		return confirmStyle.Render(msg + "\n\n" + m.confirmInput.View())
+	}
+	return confirmStyle.Render(msg)
 }
 
 func (m Model) viewForm() string {
-	return m.form.View()
+	var stmt db.Statement
+	var err error
+	if m.showSQL {
+		stmt, err = m.formStatement()
+	}
+	return m.form.View() + m.renderPreview(stmt, err)
 }
@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// formStatement builds the statement saving the form would run.
+func (m Model) formStatement() (db.Statement, error) {
+	if m.form.mode == FormModeInsert {
+		data, err := m.form.GetData()
+		if err != nil {
+			return db.Statement{}, err
+		}
+		return db.BuildInsert(m.driver, m.currentTable, data)
+	}
+
+	data := m.form.GetChangedData()
+	if len(data) == 0 {
+		return db.Statement{}, fmt.Errorf("no changes made")
+	}
+	return db.BuildCheckedUpdate(m.driver, m.currentTable, m.pkColumn, m.form.pkValue, data, m.expected(m.form.original, data))
+}
+
+// handlePreviewKey handles the keys shared by the form and confirm dialogs:
+// ctrl+p shows or hides the SQL and ctrl+r dry-runs it. stmt returns the
+// statement the dialog would run.
+func (m Model) handlePreviewKey(msg tea.Msg, stmt func() (db.Statement, error)) (Model, bool) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, false
+	}
+	switch key.String() {
+	case "ctrl+p":
+		m.showSQL = !m.showSQL
+		return m, true
+	case "ctrl+r":
+		s, err := stmt()
+		if err == nil {
+			var n int64
+			n, err = db.DryRun(m.querier(), s)
+			m.dryRunResult = fmt.Sprintf("Dry run: %d row(s) affected, then rolled back", n)
+		}
+		if err != nil {
+			m.dryRunResult = "Dry run failed: " + err.Error()
+		}
+		return m, true
+	}
+	return m, false
+}
+
+// renderPreview is the part of a dialog below its content: the statement
+// with its arguments filled in, if shown, and the last dry run's result.
+func (m Model) renderPreview(stmt db.Statement, err error) string {
+	var b strings.Builder
+	if m.showSQL {
+		b.WriteString("\n\nSQL:\n")
+		if err != nil {
+			b.WriteString("  (" + err.Error() + ")")
+		} else {
+			b.WriteString("  " + m.maskArgs(m.previewTable(), stmt).Interpolated())
+		}
+	}
+	if m.dryRunResult != "" {
+		b.WriteString("\n\n" + m.dryRunResult)
+	}
+	return b.String()
+}
+
+// previewTable is the table of the statement a dialog previews, whose
+// masks apply to its arguments.
+func (m Model) previewTable() string {
+	if m.confirmTable != "" {
+		return m.confirmTable
+	}
+	return m.currentTable
+}
+
+// resetPreview hides the SQL and forgets the dry run when a dialog opens
+// or closes.
+func (m *Model) resetPreview() {
+	m.showSQL = false
+	m.dryRunResult = ""
+}
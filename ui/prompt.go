@@ -0,0 +1,172 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// authRequest is a keyboard-interactive challenge from a background SSH
+// handshake, waiting for the user's answers.
+type authRequest struct {
+	ctx       context.Context
+	challenge db.AuthChallenge
+	reply     chan authReply
+}
+
+type authReply struct {
+	answers []string
+	err     error
+}
+
+// authRequestMsg delivers the next authRequest to Update.
+type authRequestMsg struct {
+	req authRequest
+}
+
+// authPrompt is the modal shown while the user answers an authRequest.
+type authPrompt struct {
+	req    authRequest
+	inputs []textinput.Model
+	focus  int
+}
+
+// newPrompter returns a db.Prompter that hands challenges to the UI through
+// requests and blocks until they are answered or ctx is done. Requests are
+// shown one at a time, in the order they arrive.
+func newPrompter(requests chan<- authRequest) db.Prompter {
+	return func(ctx context.Context, c db.AuthChallenge) ([]string, error) {
+		req := authRequest{ctx: ctx, challenge: c, reply: make(chan authReply, 1)}
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		select {
+		case r := <-req.reply:
+			return r.answers, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitAuthRequest waits for the next challenge. It is re-armed once the
+// current prompt is answered or cancelled.
+func waitAuthRequest(requests <-chan authRequest) tea.Cmd {
+	return func() tea.Msg {
+		return authRequestMsg{req: <-requests}
+	}
+}
+
+func (m Model) showAuthPrompt(req authRequest) (tea.Model, tea.Cmd) {
+	if req.ctx.Err() != nil {
+		// The connection attempt gave up before we got to it.
+		return m, waitAuthRequest(m.authRequests)
+	}
+
+	p := &authPrompt{req: req}
+	for i := range req.challenge.Questions {
+		ti := textinput.New()
+		ti.Prompt = ""
+		ti.CharLimit = 256
+		ti.Width = 40
+		if i < len(req.challenge.Echo) && !req.challenge.Echo[i] {
+			ti.EchoMode = textinput.EchoPassword
+			ti.EchoCharacter = '•'
+		}
+		p.inputs = append(p.inputs, ti)
+	}
+	p.inputs[0].Focus()
+
+	m.authPrompt = p
+	return m, textinput.Blink
+}
+
+func (m Model) updateAuthPrompt(msg tea.Msg) (tea.Model, tea.Cmd) {
+	p := m.authPrompt
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "esc":
+			return m, m.finishAuthPrompt(authReply{err: errors.New("authentication cancelled")})
+		case "tab", "down":
+			m.focusAuthInput((p.focus + 1) % len(p.inputs))
+			return m, nil
+		case "shift+tab", "up":
+			m.focusAuthInput((p.focus - 1 + len(p.inputs)) % len(p.inputs))
+			return m, nil
+		case "enter":
+			if p.focus < len(p.inputs)-1 {
+				m.focusAuthInput(p.focus + 1)
+				return m, nil
+			}
+			answers := make([]string, len(p.inputs))
+			for i, in := range p.inputs {
+				answers[i] = in.Value()
+			}
+			return m, m.finishAuthPrompt(authReply{answers: answers})
+		}
+	}
+
+	var cmd tea.Cmd
+	p.inputs[p.focus], cmd = p.inputs[p.focus].Update(msg)
+	return m, cmd
+}
+
+func (m *Model) focusAuthInput(i int) {
+	p := m.authPrompt
+	p.inputs[p.focus].Blur()
+	p.focus = i
+	p.inputs[p.focus].Focus()
+}
+
+// finishAuthPrompt hands reply to the waiting handshake and closes the
+// prompt.
+func (m *Model) finishAuthPrompt(reply authReply) tea.Cmd {
+	req := m.authPrompt.req
+	m.authPrompt = nil
+	if req.ctx.Err() != nil {
+		m.statusMsg = fmt.Sprintf("⚠ Authentication for %s timed out", req.challenge.Host)
+	}
+	req.reply <- reply
+	return waitAuthRequest(m.authRequests)
+}
+
+func (m Model) viewAuthPrompt() string {
+	p := m.authPrompt
+	c := p.req.challenge
+
+	var b strings.Builder
+	b.WriteString(TitleStyle.Render("SSH Authentication"))
+	b.WriteString("\n\n")
+	b.WriteString(fmt.Sprintf("%s@%s\n", c.User, c.Host))
+	if c.Name != "" {
+		b.WriteString(c.Name + "\n")
+	}
+	if c.Instruction != "" {
+		b.WriteString(HelpDescStyle.Render(strings.TrimSpace(c.Instruction)) + "\n")
+	}
+	b.WriteString("\n")
+
+	for i, in := range p.inputs {
+		b.WriteString(HelpKeyStyle.Render(strings.TrimSpace(c.Questions[i])))
+		b.WriteString("\n")
+		b.WriteString(in.View())
+		b.WriteString("\n\n")
+	}
+
+	b.WriteString(HelpDescStyle.Render("enter: submit • tab: next • esc: cancel"))
+
+	return lipgloss.NewStyle().
+		Padding(2, 4).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(DraculaPink).
+		Background(DraculaBackground).
+		Render(b.String())
+}
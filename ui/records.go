@@ -0,0 +1,227 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qyinm/lazyadmin/audit"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// insertRecord adds data as a row of the current table. Like updateRecord
+// and deleteRecord, it checks the role allows it, stages the change if a
+// transaction is open, records it in the audit log whether or not it
+// succeeds, and pushes it onto the undo stack.
+func (m *Model) insertRecord(data map[string]interface{}) error {
+	table, pkColumn := m.currentTable, m.pkColumn
+	if err := m.allow(config.ActionInsert, table); err != nil {
+		return err
+	}
+	stmt, err := db.BuildInsert(m.driver, table, data)
+	if err != nil {
+		return err
+	}
+
+	var change db.Change
+	err = m.write(func(q db.Querier) error {
+		var err error
+		change, err = db.InsertRecord(q, m.driver, table, pkColumn, data)
+		return err
+	})
+
+	entry := audit.Entry{Action: "INSERT", Table: table, After: audit.Image(change.After), SQL: stmt.SQL, Args: stmt.Args}
+	if entry.After == nil {
+		entry.After = audit.Image(data)
+	}
+	key := ""
+	if change.PKValue != nil {
+		key = fmt.Sprint(change.PKValue)
+		entry.Key = map[string]interface{}{pkColumn: change.PKValue}
+	}
+	m.audit(entry, err)
+	if err == nil {
+		m.stage("INSERT", table, pkColumn, key, stmt)
+		m.remember(change)
+	}
+	return err
+}
+
+// updateRecord is checked against concurrent changes: the row must still
+// hold the values in expect (see expected), or it fails with a
+// *db.StaleError.
+func (m *Model) updateRecord(table, pkColumn string, pkValue interface{}, data, expect map[string]interface{}) error {
+	if err := m.allow(config.ActionUpdate, table); err != nil {
+		return err
+	}
+	stmt, err := db.BuildCheckedUpdate(m.driver, table, pkColumn, pkValue, data, expect)
+	if err != nil {
+		return err
+	}
+
+	var change db.Change
+	err = m.write(func(q db.Querier) error {
+		var err error
+		change, err = db.UpdateRecordIfUnchanged(q, m.driver, table, pkColumn, pkValue, data, expect)
+		return err
+	})
+
+	m.audit(audit.Entry{
+		Action: "UPDATE",
+		Table:  table,
+		Key:    map[string]interface{}{pkColumn: pkValue},
+		Before: audit.Image(change.Before),
+		After:  audit.Image(change.After),
+		SQL:    stmt.SQL,
+		Args:   stmt.Args,
+	}, err)
+	if err == nil {
+		m.stage("UPDATE", table, pkColumn, fmt.Sprint(change.PKValue), stmt)
+		m.remember(change)
+	}
+	return err
+}
+
+// applyBatch updates (with data) or deletes (with nil data) the rows with
+// keys in one transaction, so either all of them change or none does. Each
+// row is audited, staged and pushed onto the undo stack on its own.
+func (m *Model) applyBatch(table, pkColumn string, keys []string, data map[string]interface{}) error {
+	action := config.ActionDelete
+	if data != nil {
+		action = config.ActionUpdate
+	}
+	if err := m.allow(action, table); err != nil {
+		return err
+	}
+
+	changes := make([]db.Change, 0, len(keys))
+	err := m.writeAll(func(q db.Querier) error {
+		for _, key := range keys {
+			var change db.Change
+			var err error
+			if data == nil {
+				change, err = db.DeleteRecord(q, m.driver, table, pkColumn, key)
+			} else {
+				change, err = db.UpdateRecord(q, m.driver, table, pkColumn, key, data)
+			}
+			changes = append(changes, change)
+			if err != nil {
+				return fmt.Errorf("%s = %s: %w", pkColumn, key, err)
+			}
+		}
+		return nil
+	})
+
+	kind := "DELETE"
+	if data != nil {
+		kind = "UPDATE"
+	}
+	for i, stmt := range m.batchStatements(table, pkColumn, keys, data) {
+		key := keys[i]
+		var change db.Change
+		if i < len(changes) {
+			change = changes[i]
+		}
+		m.audit(audit.Entry{
+			Action: kind,
+			Table:  table,
+			Key:    map[string]interface{}{pkColumn: key},
+			Before: audit.Image(change.Before),
+			After:  audit.Image(change.After),
+			SQL:    stmt.SQL,
+			Args:   stmt.Args,
+		}, err)
+		if err == nil {
+			m.stage(kind, table, pkColumn, key, stmt)
+			m.remember(change)
+		}
+	}
+	return err
+}
+
+// expected picks what an edit from original to data checks for concurrent
+// changes: the connection's version column if the row has one, otherwise
+// the original value of every edited column.
+func (m Model) expected(original, data map[string]interface{}) map[string]interface{} {
+	if original == nil {
+		return nil
+	}
+	if s := m.activeSession(); s != nil && s.cfg.VersionColumn != "" {
+		if v, ok := original[s.cfg.VersionColumn]; ok {
+			return map[string]interface{}{s.cfg.VersionColumn: v}
+		}
+	}
+	expect := make(map[string]interface{}, len(data))
+	for col := range data {
+		if v, ok := original[col]; ok {
+			expect[col] = v
+		}
+	}
+	return expect
+}
+
+func (m *Model) deleteRecord(table, pkColumn string, pkValue interface{}) error {
+	if err := m.allow(config.ActionDelete, table); err != nil {
+		return err
+	}
+	stmt := db.BuildDelete(m.driver, table, pkColumn, pkValue)
+
+	var change db.Change
+	err := m.write(func(q db.Querier) error {
+		var err error
+		change, err = db.DeleteRecord(q, m.driver, table, pkColumn, pkValue)
+		return err
+	})
+
+	m.audit(audit.Entry{
+		Action: "DELETE",
+		Table:  table,
+		Key:    map[string]interface{}{pkColumn: pkValue},
+		Before: audit.Image(change.Before),
+		SQL:    stmt.SQL,
+		Args:   stmt.Args,
+	}, err)
+	if err == nil {
+		m.stage("DELETE", table, pkColumn, fmt.Sprint(pkValue), stmt)
+		m.remember(change)
+	}
+	return err
+}
+
+// auditLogPath is where the local audit log is written.
+func (m Model) auditLogPath() string {
+	if m.config.AuditLog != "" {
+		return config.ExpandHome(m.config.AuditLog)
+	}
+	return audit.DefaultPath()
+}
+
+// audit fills in who, when and where for e and writes it to the local audit
+// log and the connection's audit table, if it has one. err is the outcome of
+// the audited action. A failure to write the entry is kept in m.auditErr
+// and shown in the status bar; it doesn't undo the action.
+func (m *Model) audit(e audit.Entry, err error) {
+	e.Time = time.Now()
+	e.User = audit.CurrentUser()
+	e.Connection = m.connLabel
+	switch {
+	case err != nil:
+		e.Outcome = audit.OutcomeError
+		e.Error = err.Error()
+	case m.inTransaction():
+		e.Outcome = audit.OutcomePending
+	default:
+		e.Outcome = audit.OutcomeOK
+	}
+
+	m.auditErr = audit.Log(e, audit.FileSink{Path: m.auditLogPath()})
+	if s := m.activeSession(); s != nil && s.cfg.AuditTable != "" {
+		// Inside a transaction the row commits or rolls back with the
+		// change it describes; the local log keeps every attempt.
+		err := m.write(func(q db.Querier) error {
+			return audit.TableSink{DB: q, Driver: m.driver, Table: s.cfg.AuditTable}.Write(e)
+		})
+		m.auditErr = errors.Join(m.auditErr, err)
+	}
+}
@@ -0,0 +1,201 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/config"
+)
+
+const configPollInterval = time.Second
+
+type configTickMsg time.Time
+
+// fileStamp identifies one version of a watched config file. A zero stamp
+// means the file does not exist.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func watchConfig() tea.Cmd {
+	return tea.Tick(configPollInterval, func(t time.Time) tea.Msg {
+		return configTickMsg(t)
+	})
+}
+
+// watchedFiles returns every layer of the current config plus the user
+// layer, so that creating the user file also triggers a reload.
+func (m Model) watchedFiles() []string {
+	files := m.config.Files()
+	if len(files) == 0 {
+		files = []string{m.configPath}
+	}
+	if p := config.UserConfigPath(); p != "" {
+		files = append(files, p)
+	}
+	return files
+}
+
+func statConfigFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		var s fileStamp
+		if info, err := os.Stat(f); err == nil {
+			s = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+		stamps[f] = s
+	}
+	return stamps
+}
+
+// snapshotConfig records the current state of the watched files. It is
+// called after lazyadmin writes the config itself so that its own saves
+// do not trigger a reload.
+func (m *Model) snapshotConfig() {
+	m.configStamps = statConfigFiles(m.watchedFiles())
+}
+
+func (m Model) configChanged() bool {
+	current := statConfigFiles(m.watchedFiles())
+	if len(current) != len(m.configStamps) {
+		return true
+	}
+	for f, s := range current {
+		if m.configStamps[f] != s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Model) handleConfigTick() (Model, tea.Cmd) {
+	if !m.configChanged() {
+		return m, watchConfig()
+	}
+	m.snapshotConfig()
+
+	cfg, err := config.Load(m.configPath)
+	if err != nil {
+		m.statusMsg = fmt.Sprintf("⚠ Config reload failed: %v", err)
+		return m, watchConfig()
+	}
+	access, err := cfg.Access(m.access.User, m.access.Requested)
+	if err != nil {
+		m.statusMsg = fmt.Sprintf("⚠ Config reload failed: %v", err)
+		return m, watchConfig()
+	}
+	m.access = access
+
+	active := m.activeSession()
+	var masks []config.Mask
+	if active != nil {
+		masks = active.cfg.Masks
+	}
+	m.statusMsg = "Config reloaded"
+	m.applyConfig(cfg)
+	m.snapshotConfig()
+	if closed := m.closeDenied(); len(closed) > 0 {
+		m.statusMsg = fmt.Sprintf("Config reloaded; closed %s, which your role no longer allows", strings.Join(closed, ", "))
+	}
+
+	// Rows on screen were masked by the old rules. A tab shown in place of
+	// a closed one kept no rows under other masks (see applyConfig).
+	if s := m.activeSession(); s != nil && s == active && !slices.Equal(masks, s.cfg.Masks) {
+		model, cmd := m.reloadRows()
+		if next, ok := model.(Model); ok {
+			next.statusMsg = "Config reloaded; masks updated"
+			m = next
+		}
+		return m, tea.Batch(cmd, watchConfig())
+	}
+	return m, watchConfig()
+}
+
+// closeDenied closes the tabs whose connection the current role doesn't
+// allow, rolling back their pending changes, and returns their labels.
+func (m *Model) closeDenied() []string {
+	var closed []string
+	for i := len(m.sessions) - 1; i >= 0; i-- {
+		if label := m.sessions[i].cfg.Label; m.access.Connection(label) != nil {
+			m.closeSession(i)
+			closed = append([]string{label}, closed...)
+		}
+	}
+	return closed
+}
+
+// applyConfig swaps in a freshly loaded config, keeping the selected
+// connection and view when they still exist.
+func (m *Model) applyConfig(cfg *config.Config) {
+	selectedConn := ""
+	if item, ok := m.connSidebar.SelectedItem().(ViewItem); ok {
+		selectedConn = item.title
+	}
+	selectedView := ""
+	if item, ok := m.sidebar.SelectedItem().(ViewItem); ok && !item.isTable {
+		selectedView = item.title
+	}
+
+	m.config = cfg
+
+	// Open tabs pick up settings that don't need a new connection. Their
+	// target and credentials change on the next connect, as does read_only
+	// inside the database itself; lazyadmin enforces it right away. Rows
+	// kept for other tabs under different masks are dropped; the caller
+	// reloads the active tab's.
+	for i, s := range m.sessions {
+		if j := connectionIndex(cfg, s.cfg.Label); j >= 0 {
+			c := cfg.Connections[j]
+			if i != m.active && !slices.Equal(s.cfg.Masks, c.Masks) {
+				s.tableCols, s.tableRows, s.tableLoaded = nil, nil, false
+			}
+			s.applySettings(c)
+		}
+	}
+
+	m.refreshConnectionList()
+	selectItem(&m.connSidebar, selectedConn)
+
+	if m.mode == ModeView {
+		m.refreshSidebarList()
+		selectItem(&m.sidebar, selectedView)
+	}
+
+	if m.connLabel != "" && connectionIndex(cfg, m.connLabel) < 0 {
+		m.statusMsg = fmt.Sprintf("Connection %q was removed from the config", m.connLabel)
+	}
+}
+
+// applySettings copies the settings of c that take effect without
+// reconnecting into s.
+func (s *session) applySettings(c config.DatabaseConfig) {
+	s.cfg.ReadOnly, s.cfg.Environment = c.ReadOnly, c.Environment
+	s.cfg.AuditTable, s.cfg.VersionColumn, s.cfg.Masks = c.AuditTable, c.VersionColumn, c.Masks
+}
+
+func selectItem(l *list.Model, title string) {
+	if title == "" {
+		return
+	}
+	for i, item := range l.Items() {
+		if v, ok := item.(ViewItem); ok && v.title == title {
+			l.Select(i)
+			return
+		}
+	}
+}
+
+func connectionIndex(cfg *config.Config, label string) int {
+	for i, c := range cfg.Connections {
+		if c.Label == label {
+			return i
+		}
+	}
+	return -1
+}
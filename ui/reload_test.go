@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+func TestConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lazyadmin.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+	stamp := time.Now().Add(-time.Hour)
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Each version gets its own modification time, however fast the
+		// test runs.
+		stamp = stamp.Add(time.Second)
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`connections:
+  - label: Alpha
+    driver: sqlite
+    path: alpha.db
+  - label: Beta
+    driver: sqlite
+    path: beta.db
+views:
+  - title: Users
+    query: SELECT * FROM users
+  - title: Orders
+    query: SELECT * FROM orders
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel(cfg, path, config.Access{})
+	m.refreshConnectionList()
+	m.refreshSidebarList()
+	m.snapshotConfig()
+	m.connSidebar.Select(1)
+	m.sidebar.Select(1)
+
+	selected := func(m Model) (string, string) {
+		conn, _ := m.connSidebar.SelectedItem().(ViewItem)
+		view, _ := m.sidebar.SelectedItem().(ViewItem)
+		return conn.title, view.title
+	}
+
+	// Entries added in front of the selected ones don't move the selection.
+	write(`connections:
+  - label: Local
+    driver: sqlite
+    path: local.db
+  - label: Alpha
+    driver: sqlite
+    path: alpha.db
+  - label: Beta
+    driver: sqlite
+    path: beta.db
+views:
+  - title: Audit
+    query: SELECT * FROM audit
+  - title: Users
+    query: SELECT * FROM users
+  - title: Orders
+    query: SELECT * FROM orders
+`)
+	m, _ = m.handleConfigTick()
+	if len(m.config.Connections) != 3 || len(m.config.Views) != 3 {
+		t.Fatalf("after reload: %d connections, %d views; want 3 of each (%s)", len(m.config.Connections), len(m.config.Views), m.statusMsg)
+	}
+	if conn, view := selected(m); !strings.Contains(conn, "Beta") || view != "Orders" {
+		t.Errorf("selection after reload = %q, %q; want Beta and Orders", conn, view)
+	}
+
+	// An invalid file is reported and the working config kept.
+	write("connections:\n  - label: Broken\n    driver: nosuchdb\n")
+	m, _ = m.handleConfigTick()
+	if !strings.Contains(m.statusMsg, "Config reload failed") {
+		t.Errorf("status after an invalid edit = %q", m.statusMsg)
+	}
+	if len(m.config.Connections) != 3 {
+		t.Errorf("an invalid edit replaced the config: %d connections", len(m.config.Connections))
+	}
+
+	// lazyadmin's own saves don't trigger a reload.
+	write(`connections:
+  - label: Alpha
+    driver: sqlite
+    path: alpha.db
+`)
+	m, _ = m.handleConfigTick()
+	m.config.Connections = append(m.config.Connections, config.DatabaseConfig{Label: "Added", Driver: "sqlite", Path: "added.db"})
+	if err := m.saveConnections(); err != nil {
+		t.Fatal(err)
+	}
+	m.statusMsg = ""
+	if m.configChanged() {
+		t.Error("saving the config counts as a change to reload")
+	}
+	m, _ = m.handleConfigTick()
+	if m.statusMsg != "" {
+		t.Errorf("a tick after saving reloaded the config: %q", m.statusMsg)
+	}
+}
+
+func TestReloadClosesDeniedTabs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lazyadmin.yaml")
+	t.Setenv("LAZYADMIN_USER_CONFIG", "")
+	connections := "connections:\n" +
+		"  - label: Alpha\n    driver: sqlite\n    path: " + filepath.Join(dir, "alpha.db") + "\n" +
+		"  - label: Beta\n    driver: sqlite\n    path: " + filepath.Join(dir, "beta.db") + "\n"
+	if err := os.WriteFile(path, []byte(connections+"roles:\n  default:\n    connections: [Alpha, Beta]\n    actions: [read]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := cfg.Access("carol", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(cfg, path, access)
+	for _, c := range cfg.Connections {
+		conn, err := db.Connect(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		m.openSession(newSession(c, conn), true)
+	}
+	m.snapshotConfig()
+
+	if err := os.WriteFile(path, []byte(connections+"roles:\n  default:\n    connections: [Alpha]\n    actions: [read]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = m.handleConfigTick()
+
+	if len(m.sessions) != 1 || m.sessions[0].cfg.Label != "Alpha" {
+		var open []string
+		for _, s := range m.sessions {
+			open = append(open, s.cfg.Label)
+		}
+		t.Errorf("open tabs after the role lost Beta = %v, want [Alpha]", open)
+	}
+	if m.connLabel != "Alpha" || !strings.Contains(m.statusMsg, "Beta") {
+		t.Errorf("active tab %q, status %q; want Alpha and Beta's tab reported closed", m.connLabel, m.statusMsg)
+	}
+}
@@ -0,0 +1,440 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// setResult replaces the loaded rows and shows them through the filter
+// and selection.
+func (m *Model) setResult(cols []table.Column, rows []table.Row) {
+	m.resultCols, m.resultRows = cols, rows
+	m.showRows()
+}
+
+// resetRows forgets the filter and selection, for when other rows are about
+// to be loaded.
+func (m *Model) resetRows() {
+	m.filter = ""
+	m.filtering = false
+	m.selected = nil
+	m.anchor = ""
+}
+
+// showRows puts the loaded rows that match the filter into the table,
+// marking the selected ones.
+func (m *Model) showRows() {
+	cols, rows := m.resultCols, m.visibleRows()
+	if len(m.selected) > 0 {
+		if pk := columnIndex(cols, m.pkColumn); pk >= 0 {
+			marked := make([]table.Row, len(rows))
+			for i, row := range rows {
+				mark := ""
+				if m.selected[row[pk]] {
+					mark = "●"
+				}
+				marked[i] = append(table.Row{mark}, row...)
+			}
+			cols, rows = append([]table.Column{{Title: "✓", Width: 2}}, cols...), marked
+		}
+	}
+
+	cursor := m.table.Cursor()
+	m.table.SetRows([]table.Row{})
+	m.table.SetColumns(cols)
+	m.table.SetRows(rows)
+	m.table.SetCursor(max(min(cursor, len(rows)-1), 0))
+}
+
+// visibleRows returns the loaded rows that contain the filter text in any
+// column, ignoring case.
+func (m Model) visibleRows() []table.Row {
+	if m.filter == "" {
+		return m.resultRows
+	}
+	needle := strings.ToLower(m.filter)
+	var rows []table.Row
+	for _, row := range m.resultRows {
+		for _, cell := range row {
+			if strings.Contains(strings.ToLower(cell), needle) {
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	return rows
+}
+
+func columnIndex(cols []table.Column, title string) int {
+	if title == "" {
+		return -1
+	}
+	for i, col := range cols {
+		if col.Title == title {
+			return i
+		}
+	}
+	return -1
+}
+
+// cursorKey returns the primary key of the row under the cursor.
+func (m Model) cursorKey() (string, bool) {
+	row := m.table.SelectedRow()
+	pk := columnIndex(m.table.Columns(), m.pkColumn)
+	if row == nil || pk < 0 {
+		return "", false
+	}
+	return row[pk], true
+}
+
+// selectedKeys returns the selected primary keys in table order.
+func (m Model) selectedKeys() []string {
+	pk := columnIndex(m.resultCols, m.pkColumn)
+	seen := make(map[string]bool, len(m.selected))
+	var keys []string
+	if pk >= 0 {
+		for _, row := range m.resultRows {
+			if k := row[pk]; m.selected[k] && !seen[k] {
+				keys = append(keys, k)
+				seen[k] = true
+			}
+		}
+	}
+	// Rows a refresh no longer shows are still selected.
+	var rest []string
+	for k := range m.selected {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// canSelect reports whether rows of the current table can be selected,
+// explaining why not in the status bar.
+func (m *Model) canSelect() bool {
+	if m.mode != ModeTableBrowser || !m.tableLoaded {
+		return false
+	}
+	if m.pkColumn == "" {
+		m.err = fmt.Errorf("no primary key found for table %s", m.currentTable)
+		return false
+	}
+	return true
+}
+
+func (m Model) toggleSelection() (tea.Model, tea.Cmd) {
+	if !m.canSelect() {
+		return m, nil
+	}
+	key, ok := m.cursorKey()
+	if !ok {
+		return m, nil
+	}
+	if m.selected == nil {
+		m.selected = make(map[string]bool)
+	}
+	if m.selected[key] {
+		delete(m.selected, key)
+	} else {
+		m.selected[key] = true
+	}
+	m.anchor = key
+	m.showRows()
+	m.statusMsg = fmt.Sprintf("%d selected", len(m.selected))
+	return m, nil
+}
+
+// selectRange selects every row from the last toggled one to the cursor.
+func (m Model) selectRange() (tea.Model, tea.Cmd) {
+	if !m.canSelect() {
+		return m, nil
+	}
+	rows := m.table.Rows()
+	pk := columnIndex(m.table.Columns(), m.pkColumn)
+	from := -1
+	for i, row := range rows {
+		if row[pk] == m.anchor {
+			from = i
+		}
+	}
+	if m.anchor == "" || from < 0 {
+		return m.toggleSelection()
+	}
+
+	to := m.table.Cursor()
+	if from > to {
+		from, to = to, from
+	}
+	if m.selected == nil {
+		m.selected = make(map[string]bool)
+	}
+	for _, row := range rows[from : to+1] {
+		m.selected[row[pk]] = true
+	}
+	m.showRows()
+	m.statusMsg = fmt.Sprintf("%d selected", len(m.selected))
+	return m, nil
+}
+
+// selectAllMatching selects every row the filter shows, or unselects them
+// if they all are already.
+func (m Model) selectAllMatching() (tea.Model, tea.Cmd) {
+	if !m.canSelect() {
+		return m, nil
+	}
+	pk := columnIndex(m.resultCols, m.pkColumn)
+	rows := m.visibleRows()
+	all := true
+	for _, row := range rows {
+		all = all && m.selected[row[pk]]
+	}
+	if m.selected == nil {
+		m.selected = make(map[string]bool)
+	}
+	for _, row := range rows {
+		if all {
+			delete(m.selected, row[pk])
+		} else {
+			m.selected[row[pk]] = true
+		}
+	}
+	m.showRows()
+	m.statusMsg = fmt.Sprintf("%d selected", len(m.selected))
+	return m, nil
+}
+
+func (m Model) startFilter() (tea.Model, tea.Cmd) {
+	if !m.tableLoaded {
+		return m, nil
+	}
+	ti := textinput.New()
+	ti.Prompt = "/ "
+	ti.PromptStyle = lipgloss.NewStyle().Foreground(DraculaCyan)
+	ti.CharLimit = 256
+	ti.Width = 30
+	ti.SetValue(m.filter)
+	m.filterInput = ti
+	m.filtering = true
+	return m, m.filterInput.Focus()
+}
+
+// updateFilter edits the filter, narrowing the table as it is typed.
+// Enter keeps it; esc clears it.
+func (m Model) updateFilter(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "enter":
+			m.filtering = false
+			return m, nil
+		case "esc":
+			m.filtering = false
+			m.filter = ""
+			m.showRows()
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.filterInput, cmd = m.filterInput.Update(msg)
+	m.filter = strings.TrimSpace(m.filterInput.Value())
+	m.showRows()
+	return m, cmd
+}
+
+// clearSelection drops the selection, or the filter if nothing is selected.
+func (m Model) clearSelection() (tea.Model, tea.Cmd) {
+	switch {
+	case len(m.selected) > 0:
+		m.selected = nil
+		m.anchor = ""
+		m.statusMsg = "Selection cleared"
+	case m.filter != "":
+		m.filter = ""
+		m.statusMsg = "Filter cleared"
+	default:
+		return m, nil
+	}
+	m.showRows()
+	return m, nil
+}
+
+// showBatchDeleteConfirm asks to delete every selected row. The number of
+// rows has to be typed to confirm, or on production the table name, as for
+// any production delete.
+func (m Model) showBatchDeleteConfirm() (tea.Model, tea.Cmd) {
+	keys := m.selectedKeys()
+	if len(keys) == 0 {
+		return m, nil
+	}
+	table, pkColumn := m.currentTable, m.pkColumn
+	want := strconv.Itoa(len(keys))
+	prompt := want
+
+	stmts := m.batchStatements(table, pkColumn, keys, nil)
+	m.confirmMsg = fmt.Sprintf("Delete %d selected records from %s in one transaction?", len(keys), table)
+	if m.production() {
+		m.confirmMsg = m.productionPreview(m.confirmMsg, stmts...)
+		want, prompt = table, fmt.Sprintf("%q", table)
+	}
+	m.confirmMsg += fmt.Sprintf("\n\nType %s to confirm, or esc to cancel.", prompt)
+	m.confirmStmt = &stmts[0]
+	cmd := m.expectTyped(want)
+	m.confirmReturn = FocusTable
+	m.confirmAction = func(m *Model) tea.Cmd {
+		if err := m.applyBatch(table, pkColumn, keys, nil); err != nil {
+			m.err = err
+			m.statusMsg = "Batch delete failed, nothing deleted: " + err.Error()
+			return nil
+		}
+		m.selected, m.anchor = nil, ""
+		m.statusMsg = fmt.Sprintf("Deleted %d records", len(keys)) + m.stagedSuffix()
+		return nil
+	}
+	m.focus = FocusConfirm
+	return m, cmd
+}
+
+func (m Model) startBatchSet() (tea.Model, tea.Cmd) {
+	ti := textinput.New()
+	ti.Prompt = fmt.Sprintf("Set on %d selected: ", len(m.selected))
+	ti.PromptStyle = lipgloss.NewStyle().Foreground(DraculaCyan)
+	ti.Placeholder = "column = value"
+	ti.CharLimit = 500
+	ti.Width = 40
+	m.batchInput = ti
+	m.batchSet = true
+	return m, m.batchInput.Focus()
+}
+
+// updateBatchSet edits the "column = value" of a batch update.
+func (m Model) updateBatchSet(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "enter":
+			col, value, err := m.parseAssignment(m.batchInput.Value())
+			if err != nil {
+				m.statusMsg = err.Error()
+				return m, nil
+			}
+			m.batchSet = false
+			return m.showBatchSetConfirm(col, value)
+		case "esc":
+			m.batchSet = false
+			m.statusMsg = "Cancelled"
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.batchInput, cmd = m.batchInput.Update(msg)
+	return m, cmd
+}
+
+// parseAssignment reads "column = value". The value may be single-quoted;
+// an unquoted NULL sets the column to NULL.
+func (m Model) parseAssignment(s string) (string, interface{}, error) {
+	col, value, ok := strings.Cut(s, "=")
+	col, value = strings.TrimSpace(col), strings.TrimSpace(value)
+	if !ok || col == "" {
+		return "", nil, fmt.Errorf("expected column = value")
+	}
+
+	known := false
+	for _, c := range m.columns {
+		known = known || c.Name == col
+	}
+	switch {
+	case !known:
+		return "", nil, fmt.Errorf("no column %s in %s", col, m.currentTable)
+	case col == m.pkColumn:
+		return "", nil, fmt.Errorf("can't set the primary key %s on several rows", col)
+	}
+
+	if strings.EqualFold(value, "null") {
+		return col, nil, nil
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return col, value, nil
+}
+
+func (m Model) showBatchSetConfirm(col string, value interface{}) (tea.Model, tea.Cmd) {
+	keys := m.selectedKeys()
+	if len(keys) == 0 {
+		return m, nil
+	}
+	table, pkColumn := m.currentTable, m.pkColumn
+	data := map[string]interface{}{col: value}
+
+	shown := "NULL"
+	if value != nil {
+		shown = fmt.Sprintf("'%v'", value)
+	}
+	stmts := m.batchStatements(table, pkColumn, keys, data)
+	m.confirmMsg = fmt.Sprintf("Set %s = %s on %d selected records of %s in one transaction?", col, shown, len(keys), table)
+	m.confirmStmt = &stmts[0]
+	var cmd tea.Cmd
+	if m.production() {
+		n := strconv.Itoa(len(keys))
+		m.confirmMsg = m.productionPreview(m.confirmMsg, stmts...) + fmt.Sprintf("\n\nType %s to confirm, or esc to cancel.", n)
+		cmd = m.expectTyped(n)
+	} else {
+		m.confirmMsg += " (y/n)"
+	}
+	m.confirmReturn = FocusTable
+	m.confirmAction = func(m *Model) tea.Cmd {
+		if err := m.applyBatch(table, pkColumn, keys, data); err != nil {
+			m.err = err
+			m.statusMsg = "Batch update failed, nothing changed: " + err.Error()
+			return nil
+		}
+		m.statusMsg = fmt.Sprintf("Updated %d records", len(keys)) + m.stagedSuffix()
+		return nil
+	}
+	m.focus = FocusConfirm
+	return m, cmd
+}
+
+// batchStatements builds the statement a batch runs for each key: a DELETE
+// if data is nil, otherwise an UPDATE setting data.
+func (m Model) batchStatements(table, pkColumn string, keys []string, data map[string]interface{}) []db.Statement {
+	stmts := make([]db.Statement, len(keys))
+	for i, key := range keys {
+		if data == nil {
+			stmts[i] = db.BuildDelete(m.driver, table, pkColumn, key)
+		} else {
+			stmts[i], _ = db.BuildUpdate(m.driver, table, pkColumn, key, data)
+		}
+	}
+	return stmts
+}
+
+// renderSelectionBar describes the filter and selection above the table.
+func (m Model) renderSelectionBar() string {
+	var parts []string
+	switch {
+	case m.filtering:
+		parts = append(parts, m.filterInput.View())
+	case m.filter != "":
+		parts = append(parts, "Filter: "+m.filter)
+	}
+	if m.filtering || m.filter != "" {
+		parts = append(parts, fmt.Sprintf("%d of %d rows", len(m.visibleRows()), len(m.resultRows)))
+	}
+	if m.batchSet {
+		parts = append(parts, m.batchInput.View())
+	} else if len(m.selected) > 0 {
+		parts = append(parts, fmt.Sprintf("%d selected  %s %s",
+			len(m.selected), HelpKeyStyle.Render("d/U"), HelpDescStyle.Render("delete/set column  esc clear")))
+	}
+	return strings.Join(parts, "  •  ")
+}
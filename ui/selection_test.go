@@ -0,0 +1,165 @@
+package ui
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// browseUsers opens a users table with five rows in the table browser.
+// Rows 3 and 4 are the only ones at example.org.
+func browseUsers(t *testing.T, environment string) Model {
+	t.Helper()
+	cfg := config.DatabaseConfig{Label: "Local", Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db"), Environment: environment}
+	conn, err := db.Connect(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.DB.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, note TEXT);
+		INSERT INTO users (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com'),
+			(3, 'c@example.org'), (4, 'd@example.org'), (5, 'e@example.com');
+		CREATE TRIGGER protect_delete BEFORE DELETE ON users WHEN old.note = 'protected'
+			BEGIN SELECT RAISE(ABORT, 'row is protected'); END;
+		CREATE TRIGGER protect_update BEFORE UPDATE ON users WHEN old.note = 'protected'
+			BEGIN SELECT RAISE(ABORT, 'row is protected'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&config.Config{Connections: []config.DatabaseConfig{cfg}}, "", config.Access{})
+	m.openSession(newSession(cfg, conn), true)
+	next, _ := m.toggleMode()
+	next, _ = next.(Model).handleSidebarSelect()
+	m = next.(Model)
+	if m.err != nil || m.currentTable != "users" {
+		t.Fatalf("browsing users: table %q, err %v", m.currentTable, m.err)
+	}
+	return m
+}
+
+// protect makes deleting or updating the user with id fail.
+func protect(t *testing.T, m Model, id int) {
+	t.Helper()
+	if _, err := m.db.Exec("UPDATE users SET note = 'protected' WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func userIDs(t *testing.T, m Model, where string) []int {
+	t.Helper()
+	rows, err := m.db.Query("SELECT id FROM users WHERE " + where + " ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// confirmTyped types text into the open confirmation and presses enter.
+func confirmTyped(t *testing.T, m Model, text string) Model {
+	t.Helper()
+	if m.focus != FocusConfirm {
+		t.Fatalf("no confirmation open: %s", m.statusMsg)
+	}
+	if m.confirmExpect != text {
+		t.Fatalf("confirmation expects %q, want %q", m.confirmExpect, text)
+	}
+	m.confirmInput.SetValue(text)
+	next, _ := m.updateConfirm(tea.KeyMsg{Type: tea.KeyEnter})
+	return next.(Model)
+}
+
+func TestBatchDeleteOnlySelectedRows(t *testing.T) {
+	m := browseUsers(t, "")
+
+	// Select everything the filter shows, then one more row without it.
+	m.filter = "example.org"
+	m.showRows()
+	next, _ := m.selectAllMatching()
+	m = next.(Model)
+	next, _ = m.clearSelection()
+	if m = next.(Model); len(m.selected) != 0 {
+		t.Fatal("esc cleared the selection before the filter")
+	}
+	next, _ = m.selectAllMatching()
+	m = next.(Model)
+	m.filter = ""
+	m.showRows()
+	m.table.SetCursor(0)
+	next, _ = m.toggleSelection()
+	m = next.(Model)
+	if got := m.selectedKeys(); !reflect.DeepEqual(got, []string{"1", "3", "4"}) {
+		t.Fatalf("selected %v, want [1 3 4]", got)
+	}
+
+	next, _ = m.showBatchDeleteConfirm()
+	m = confirmTyped(t, next.(Model), "3")
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	if got := userIDs(t, m, "1 = 1"); !reflect.DeepEqual(got, []int{2, 5}) {
+		t.Errorf("users left = %v, want [2 5]", got)
+	}
+}
+
+func TestBatchRollsBackWhenARowFails(t *testing.T) {
+	m := browseUsers(t, "")
+	protect(t, m, 4)
+
+	// Select rows 2 to 5 as a range.
+	m.table.SetCursor(1)
+	next, _ := m.toggleSelection()
+	m = next.(Model)
+	m.table.SetCursor(4)
+	next, _ = m.selectRange()
+	m = next.(Model)
+	if got := m.selectedKeys(); !reflect.DeepEqual(got, []string{"2", "3", "4", "5"}) {
+		t.Fatalf("selected %v, want [2 3 4 5]", got)
+	}
+
+	next, _ = m.showBatchSetConfirm("email", "x@example.net")
+	next, _ = next.(Model).updateConfirm(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = next.(Model)
+	if m.err == nil {
+		t.Error("batch update with a failing row succeeded")
+	}
+	if got := userIDs(t, m, "email = 'x@example.net'"); len(got) != 0 {
+		t.Errorf("rows updated by a failed batch: %v", got)
+	}
+
+	next, _ = m.showBatchDeleteConfirm()
+	m = confirmTyped(t, next.(Model), "4")
+	if m.err == nil {
+		t.Error("batch delete with a failing row succeeded")
+	}
+	if got := userIDs(t, m, "1 = 1"); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("users left after a failed batch delete = %v, want all of them", got)
+	}
+}
+
+func TestBatchDeleteOnProductionNeedsTableName(t *testing.T) {
+	m := browseUsers(t, "production")
+	m.table.SetCursor(0)
+	next, _ := m.toggleSelection()
+	next, _ = next.(Model).showBatchDeleteConfirm()
+	m = confirmTyped(t, next.(Model), "users")
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	if got := userIDs(t, m, "id = 1"); len(got) != 0 {
+		t.Error("the selected row wasn't deleted")
+	}
+}
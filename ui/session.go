@@ -0,0 +1,339 @@
+package ui
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// session is one open connection together with the browsing state of its
+// tab. The active session's state lives in the Model fields while it is
+// shown; saveSession and loadSession move it in and out.
+type session struct {
+	cfg  config.DatabaseConfig
+	conn *db.Connection
+
+	// tx is open while edits are being staged; pending lists them.
+	tx      *sql.Tx
+	pending []pendingChange
+
+	// undo holds the changes u can reverse, newest last. undoMark is its
+	// length when tx began, so rolling back drops the changes it discarded.
+	undo     []db.Change
+	undoMark int
+
+	// revealed shows masked columns unmasked, where the role allows it.
+	revealed bool
+
+	mode         Mode
+	tables       []db.TableInfo
+	currentTable string
+	pkColumn     string
+	columns      []db.ColumnInfo
+	tableLoaded  bool
+	tableCols    []table.Column
+	tableRows    []table.Row
+	filter       string
+	selected     map[string]bool
+	anchor       string
+	cursor       int
+	sidebarIndex int
+}
+
+func newSession(cfg config.DatabaseConfig, conn *db.Connection) *session {
+	return &session{cfg: cfg, conn: conn, mode: ModeView}
+}
+
+func (m Model) activeSession() *session {
+	if m.active < 0 || m.active >= len(m.sessions) {
+		return nil
+	}
+	return m.sessions[m.active]
+}
+
+func (m Model) sessionIndex(label string) int {
+	for i, s := range m.sessions {
+		if s.cfg.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveSession stores the visible browsing state into the active session.
+func (m *Model) saveSession() {
+	s := m.activeSession()
+	if s == nil {
+		return
+	}
+	if m.mode == ModeView || m.mode == ModeTableBrowser {
+		s.mode = m.mode
+	}
+	s.tables = m.tables
+	s.currentTable = m.currentTable
+	s.pkColumn = m.pkColumn
+	s.columns = m.columns
+	s.tableLoaded = m.tableLoaded
+	s.tableCols = m.resultCols
+	s.tableRows = m.resultRows
+	s.filter, s.selected, s.anchor = m.filter, m.selected, m.anchor
+	s.cursor = m.table.Cursor()
+	s.sidebarIndex = m.sidebar.Index()
+}
+
+// loadSession makes session i active and restores its browsing state.
+// An index outside the session list leaves no session active.
+func (m *Model) loadSession(i int) {
+	if i < 0 || i >= len(m.sessions) {
+		m.active = -1
+		m.db = nil
+		m.driver = ""
+		m.connLabel = ""
+		m.mode = ModeView
+		m.tables = nil
+		m.currentTable = ""
+		m.pkColumn = ""
+		m.columns = nil
+		m.tableLoaded = false
+		m.resetRows()
+		m.setResult(nil, nil)
+		m.refreshSidebarList()
+		return
+	}
+
+	s := m.sessions[i]
+	m.active = i
+	m.db = s.conn.DB
+	m.driver = s.cfg.Driver
+	m.connLabel = s.cfg.Label
+	m.mode = s.mode
+	m.tables = s.tables
+	m.currentTable = s.currentTable
+	m.pkColumn = s.pkColumn
+	m.columns = s.columns
+	m.tableLoaded = s.tableLoaded
+
+	// The cursor points into the rows as filtered, so the filter and
+	// selection go back first.
+	m.resetRows()
+	m.filter, m.selected, m.anchor = s.filter, s.selected, s.anchor
+	m.setResult(s.tableCols, s.tableRows)
+	m.table.SetCursor(s.cursor)
+
+	m.refreshSidebarList()
+	m.sidebar.Select(s.sidebarIndex)
+}
+
+func (m Model) switchSession(i int) (tea.Model, tea.Cmd) {
+	if len(m.sessions) == 0 {
+		return m, nil
+	}
+	i = (i + len(m.sessions)) % len(m.sessions)
+	if i == m.active {
+		return m, nil
+	}
+
+	m.saveSession()
+	m.loadSession(i)
+	m.err = nil
+	m.statusMsg = fmt.Sprintf("Switched to %s", m.connLabel)
+	return m, nil
+}
+
+// openSession adds a freshly connected session, making it active unless
+// the user is busy in a form.
+func (m *Model) openSession(s *session, activate bool) {
+	m.sessions = append(m.sessions, s)
+	if !activate {
+		return
+	}
+	m.saveSession()
+	m.loadSession(len(m.sessions) - 1)
+}
+
+// closeSession disconnects session i and shows a neighbouring tab.
+func (m *Model) closeSession(i int) {
+	if i < 0 || i >= len(m.sessions) {
+		return
+	}
+
+	wasActive := i == m.active
+	if !wasActive {
+		m.saveSession()
+	}
+
+	s := m.sessions[i]
+	rollbackSession(s)
+	s.conn.Close()
+	delete(m.connStates, s.cfg.Label)
+	m.sessions = append(m.sessions[:i:i], m.sessions[i+1:]...)
+
+	switch {
+	case wasActive:
+		next := i
+		if next >= len(m.sessions) {
+			next = len(m.sessions) - 1
+		}
+		m.loadSession(next)
+	case i < m.active:
+		m.active--
+	}
+	m.refreshConnectionList()
+}
+
+func (m Model) disconnectActive() (tea.Model, tea.Cmd) {
+	s := m.activeSession()
+	if s == nil {
+		return m, nil
+	}
+	label := s.cfg.Label
+	lost := len(s.pending)
+	m.closeSession(m.active)
+	m.err = nil
+	m.statusMsg = fmt.Sprintf("Disconnected from %s", label)
+	if lost > 0 {
+		m.statusMsg += fmt.Sprintf(" (%d pending change(s) rolled back)", lost)
+	}
+	return m, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// watchTunnel waits for the next event from conn's SSH tunnel, if it has
+// one. It is re-armed after every event until the tunnel is closed.
+func watchTunnel(conn *db.Connection) tea.Cmd {
+	if conn.Tunnel == nil {
+		return nil
+	}
+	tunnel := conn.Tunnel
+	return func() tea.Msg {
+		ev, ok := <-tunnel.Events()
+		if !ok {
+			return nil
+		}
+		return tunnelEventMsg{tunnel: tunnel, event: ev}
+	}
+}
+
+func (m Model) handleTunnelEvent(msg tunnelEventMsg) (tea.Model, tea.Cmd) {
+	var s *session
+	for _, candidate := range m.sessions {
+		if candidate.conn.Tunnel == msg.tunnel {
+			s = candidate
+		}
+	}
+	if s == nil {
+		// The session was closed; its tunnel is going away too.
+		return m, nil
+	}
+
+	label := s.cfg.Label
+	switch ev := msg.event; {
+	case ev.State == db.TunnelReconnecting:
+		m.connStates[label] = connState{status: connConnecting, err: ev.Err}
+		m.statusMsg = fmt.Sprintf("⚠ SSH tunnel for %s: %v (reconnecting)", label, ev.Err)
+	case ev.Err != nil:
+		m.statusMsg = fmt.Sprintf("⚠ SSH tunnel for %s: %v", label, ev.Err)
+	default:
+		m.connStates[label] = connState{status: connConnected}
+		m.statusMsg = fmt.Sprintf("SSH tunnel for %s reconnected", label)
+	}
+	m.refreshConnectionList()
+	return m, watchTunnel(s.conn)
+}
+
+func (m Model) renderTabBar() string {
+	if len(m.sessions) == 0 {
+		return HelpDescStyle.Faint(true).Render(" No open connections")
+	}
+
+	tabs := make([]string, len(m.sessions))
+	for i, s := range m.sessions {
+		label := fmt.Sprintf(" %d:%s%s ", i+1, s.cfg.Label, readOnlyBadge(s.cfg))
+		if s.tx != nil {
+			label += fmt.Sprintf("[tx: %d pending] ", len(s.pending))
+		}
+		if i == m.active {
+			tabs[i] = ActiveTabStyle.Render(label)
+		} else {
+			tabs[i] = TabStyle.Render(label)
+		}
+		tabs[i] += environmentTag(s.cfg)
+	}
+	help := fmt.Sprintf("  %s %s  %s %s",
+		HelpKeyStyle.Render("[/]"),
+		HelpDescStyle.Render("switch"),
+		HelpKeyStyle.Render("x"),
+		HelpDescStyle.Render("disconnect"),
+	)
+	return lipgloss.JoinHorizontal(lipgloss.Top, strings.Join(tabs, " "), help)
+}
+
+// renderConnectionStatus lists every open session with its pool statistics
+// and, for tunnelled connections, the SSH tunnel endpoints.
+func (m Model) renderConnectionStatus() string {
+	var b strings.Builder
+	b.WriteString(TitleStyle.Render("Open Connections"))
+	b.WriteString("\n\n")
+
+	if len(m.sessions) == 0 {
+		b.WriteString(EmptyStateStyle.Render("No open connections"))
+		return b.String()
+	}
+
+	for i, s := range m.sessions {
+		stats := s.conn.Stats()
+		pool := stats.Pool
+
+		name := s.cfg.Label
+		if i == m.active {
+			name += " (active)"
+		}
+		b.WriteString(fmt.Sprintf("%s %s  %s\n", statusBadge(m.connStates[s.cfg.Label].status), HelpKeyStyle.Render(name), s.cfg.Driver))
+
+		maxOpen := "unlimited"
+		if pool.MaxOpenConnections > 0 {
+			maxOpen = fmt.Sprint(pool.MaxOpenConnections)
+		}
+		b.WriteString(fmt.Sprintf("   Pool: %d open (%d in use, %d idle), max %s\n",
+			pool.OpenConnections, pool.InUse, pool.Idle, maxOpen))
+		b.WriteString(fmt.Sprintf("   Waits: %d (%s), closed idle: %d, closed lifetime: %d\n",
+			pool.WaitCount, pool.WaitDuration, pool.MaxIdleClosed, pool.MaxLifetimeClosed))
+
+		if stats.TunnelLocalAddr != "" {
+			via := append(append([]string(nil), stats.TunnelJumpAddrs...), stats.TunnelServerAddr)
+			b.WriteString(fmt.Sprintf("   Tunnel: %s → %s via %s (%s)\n",
+				stats.TunnelLocalAddr, stats.TunnelRemoteAddr, strings.Join(via, " → "), stats.TunnelState))
+			traffic := stats.TunnelTraffic
+			b.WriteString(fmt.Sprintf("   Traffic: %s in, %s out, %d active channels (%d total), %d dial failures, %d reconnects\n",
+				formatBytes(traffic.BytesIn), formatBytes(traffic.BytesOut), traffic.ActiveChannels,
+				traffic.TotalChannels, traffic.DialFailures, traffic.Reconnects))
+			if stats.TunnelErr != nil {
+				b.WriteString(fmt.Sprintf("   Last tunnel error: %v\n", stats.TunnelErr))
+			}
+		} else {
+			b.WriteString("   Tunnel: none (direct)\n")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString(EmptyStateStyle.Render("s: Close status"))
+	return b.String()
+}
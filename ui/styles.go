@@ -10,6 +10,8 @@ var (
 	DraculaCyan       = lipgloss.Color("14")
 	DraculaGreen      = lipgloss.Color("10")
 	DraculaComment    = lipgloss.Color("7")
+	DraculaRed        = lipgloss.Color("9")
+	DraculaYellow     = lipgloss.Color("11")
 
 	SidebarStyle = lipgloss.NewStyle().
 			Padding(0, 1).
@@ -49,6 +51,14 @@ var (
 			Background(DraculaBackground).
 			Foreground(DraculaForeground)
 
+	TabStyle = lipgloss.NewStyle().
+			Foreground(DraculaComment)
+
+	ActiveTabStyle = lipgloss.NewStyle().
+			Foreground(DraculaPink).
+			Bold(true).
+			Reverse(true)
+
 	HelpKeyStyle = lipgloss.NewStyle().
 			Foreground(DraculaPink).
 			Bold(true)
@@ -0,0 +1,262 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/audit"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// pendingChange is a write made inside a session's open transaction that
+// hasn't been committed yet.
+type pendingChange struct {
+	kind   string // INSERT, UPDATE, DELETE, UNDO or SQL
+	table  string
+	keyCol string
+	key    string // primary key value as shown in the table, if known
+	stmt   db.Statement
+}
+
+// querier is where the active session's statements run: its open
+// transaction if there is one, otherwise the pool.
+func (m Model) querier() db.Querier {
+	if s := m.activeSession(); s != nil && s.tx != nil {
+		return s.tx
+	}
+	return m.db
+}
+
+func (m Model) inTransaction() bool {
+	s := m.activeSession()
+	return s != nil && s.tx != nil
+}
+
+// write runs fn against the active session. Inside a transaction it runs
+// under a savepoint, so a failed statement doesn't abort the changes staged
+// before it (as Postgres otherwise would).
+func (m Model) write(fn func(q db.Querier) error) error {
+	return m.guarded(fn)
+}
+
+// read is write for queries that change nothing: on Postgres a failing
+// SELECT aborts the transaction just the same.
+func (m Model) read(fn func(q db.Querier) error) error {
+	return m.guarded(fn)
+}
+
+func (m Model) guarded(fn func(q db.Querier) error) error {
+	s := m.activeSession()
+	if s == nil || s.tx == nil {
+		return fn(m.db)
+	}
+	if _, err := s.tx.Exec("SAVEPOINT lazyadmin_guard"); err != nil {
+		return err
+	}
+	if err := fn(s.tx); err != nil {
+		s.tx.Exec("ROLLBACK TO SAVEPOINT lazyadmin_guard")
+		return err
+	}
+	_, err := s.tx.Exec("RELEASE SAVEPOINT lazyadmin_guard")
+	return err
+}
+
+// writeAll runs fn in one transaction: the session's open one, under a
+// savepoint as with write, or else a new one that commits if fn succeeds.
+func (m Model) writeAll(fn func(q db.Querier) error) error {
+	if m.inTransaction() {
+		return m.write(fn)
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// stage records a successful write to the row of table where keyCol is
+// key as pending if a transaction is open.
+func (m *Model) stage(kind, table, keyCol, key string, stmt db.Statement) {
+	s := m.activeSession()
+	if s == nil || s.tx == nil {
+		return
+	}
+	s.pending = append(s.pending, pendingChange{kind: kind, table: table, keyCol: keyCol, key: key, stmt: stmt})
+}
+
+// stagedSuffix is appended to write status messages while they are only
+// pending.
+func (m Model) stagedSuffix() string {
+	if m.inTransaction() {
+		return " (pending commit)"
+	}
+	return ""
+}
+
+func (m Model) beginTransaction() (tea.Model, tea.Cmd) {
+	s := m.activeSession()
+	switch {
+	case s == nil:
+		m.err = fmt.Errorf("no database connection")
+		return m, nil
+	case m.readOnly():
+		return m.refuseWrite()
+	case s.tx != nil:
+		m.statusMsg = "Already in a transaction"
+		return m, nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	s.tx = tx
+	s.undoMark = len(s.undo)
+	m.err = nil
+	m.statusMsg = "Transaction started: edits are staged until C commits or R rolls back"
+	return m, nil
+}
+
+// showEndTransactionConfirm asks before committing or rolling back every
+// pending change of the active session.
+func (m Model) showEndTransactionConfirm(commit bool) (tea.Model, tea.Cmd) {
+	s := m.activeSession()
+	if s == nil || s.tx == nil {
+		m.statusMsg = "No open transaction (T to start one)"
+		return m, nil
+	}
+
+	verb := "Roll back"
+	if commit {
+		verb = "Commit"
+	}
+	m.confirmMsg = fmt.Sprintf("%s %d pending change(s) on %s? (y/n)", verb, len(s.pending), s.cfg.Label)
+	m.confirmReturn = m.focus
+	if m.confirmReturn == FocusConfirm || m.confirmReturn == FocusForm {
+		m.confirmReturn = FocusTable
+	}
+	m.confirmAction = func(m *Model) tea.Cmd {
+		n := len(s.pending)
+		var err error
+		if commit {
+			err = s.tx.Commit()
+		} else {
+			err = s.tx.Rollback()
+		}
+		if !commit || err != nil {
+			s.dropUndone()
+		}
+		s.tx, s.pending = nil, nil
+		m.showPending = false
+		action := "ROLLBACK"
+		if commit {
+			action = "COMMIT"
+		}
+		m.audit(audit.Entry{Action: action}, err)
+		if err != nil {
+			m.err = err
+			m.statusMsg = verb + " failed: " + err.Error()
+			return nil
+		}
+		if commit {
+			m.statusMsg = fmt.Sprintf("Committed %d change(s)", n)
+		} else {
+			m.statusMsg = fmt.Sprintf("Rolled back %d change(s)", n)
+		}
+		return nil
+	}
+	m.focus = FocusConfirm
+	return m, nil
+}
+
+// rollbackSession abandons s's open transaction, if any, returning how
+// many pending changes were lost.
+func rollbackSession(s *session) int {
+	if s.tx == nil {
+		return 0
+	}
+	n := len(s.pending)
+	s.tx.Rollback()
+	s.dropUndone()
+	s.tx, s.pending = nil, nil
+	return n
+}
+
+// markPending prefixes the rows of the current table with a marker column
+// when they have pending changes: + for inserted rows, ~ for updated ones.
+func (m Model) markPending(cols []table.Column, rows []table.Row) ([]table.Column, []table.Row) {
+	s := m.activeSession()
+	if s == nil || len(s.pending) == 0 || m.currentTable == "" || m.pkColumn == "" {
+		return cols, rows
+	}
+
+	marks := make(map[string]string)
+	for _, c := range s.pending {
+		if c.table != m.currentTable || c.key == "" {
+			continue
+		}
+		switch c.kind {
+		case "INSERT":
+			marks[c.key] = "+"
+		case "UPDATE":
+			if marks[c.key] == "" {
+				marks[c.key] = "~"
+			}
+		}
+	}
+	if len(marks) == 0 {
+		return cols, rows
+	}
+
+	pk := -1
+	for i, col := range cols {
+		if col.Title == m.pkColumn {
+			pk = i
+		}
+	}
+	if pk < 0 {
+		return cols, rows
+	}
+
+	marked := make([]table.Row, len(rows))
+	for i, row := range rows {
+		marked[i] = append(table.Row{marks[row[pk]]}, row...)
+	}
+	return append([]table.Column{{Title: "±", Width: 2}}, cols...), marked
+}
+
+// renderPending lists the active session's pending changes with their SQL.
+func (m Model) renderPending() string {
+	var b strings.Builder
+	b.WriteString(TitleStyle.Render("Pending Changes"))
+	b.WriteString("\n\n")
+
+	s := m.activeSession()
+	if s == nil || s.tx == nil {
+		b.WriteString(EmptyStateStyle.Render("No open transaction\n\nT: Begin transaction  P: Close"))
+		return b.String()
+	}
+	if len(s.pending) == 0 {
+		b.WriteString(EmptyStateStyle.Render("Transaction open, nothing changed yet\n\nR: Roll back  P: Close"))
+		return b.String()
+	}
+
+	for i, c := range s.pending {
+		target := c.table
+		if c.key != "" {
+			target += fmt.Sprintf(" %s = %s", c.keyCol, c.key)
+		}
+		b.WriteString(fmt.Sprintf("%d. %s %s\n", i+1, HelpKeyStyle.Render(c.kind), target))
+		b.WriteString(fmt.Sprintf("   %s\n   args: %v\n\n", c.stmt.SQL, m.maskArgs(c.table, c.stmt).Args))
+	}
+
+	b.WriteString(HelpDescStyle.Render("C: Commit  R: Roll back  P: Close"))
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/config"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// With one connection in the pool, an open transaction holds it: browsing
+// tables must go through the transaction rather than wait for the pool.
+func TestBrowseInsideTransaction(t *testing.T) {
+	cfg := config.DatabaseConfig{
+		Label:  "Local",
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "test.db"),
+		Pool:   &config.PoolConfig{MaxOpen: 1},
+	}
+	conn, err := db.Connect(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&config.Config{Connections: []config.DatabaseConfig{cfg}}, "", config.Access{})
+	s := newSession(cfg, conn)
+	m.openSession(s, true)
+	if s.tx, err = conn.DB.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.tx.Rollback()
+
+	done := make(chan Model, 1)
+	go func() {
+		var next tea.Model = m
+		next, _ = next.(Model).toggleMode()
+		next, _ = next.(Model).handleSidebarSelect()
+		done <- next.(Model)
+	}()
+	select {
+	case got := <-done:
+		if got.err != nil {
+			t.Fatal(got.err)
+		}
+		if got.currentTable != "users" || got.pkColumn != "id" || len(got.columns) != 2 {
+			t.Errorf("browsing users: table %q, key %q, %d columns", got.currentTable, got.pkColumn, len(got.columns))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("browsing tables blocked on the pool while a transaction was open")
+	}
+}
+
+// A read that fails inside a transaction is rolled back to a savepoint, as
+// a failed write is, so the transaction stays usable on Postgres.
+func TestFailedReadKeepsTransaction(t *testing.T) {
+	cfg := config.DatabaseConfig{Label: "Local", Driver: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")}
+	conn, err := db.Connect(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&config.Config{Connections: []config.DatabaseConfig{cfg}}, "", config.Access{})
+	s := newSession(cfg, conn)
+	m.openSession(s, true)
+	next, _ := m.beginTransaction()
+	m = next.(Model)
+	defer rollbackSession(s)
+
+	if _, err := s.tx.Exec("INSERT INTO users (email) VALUES ('kept@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	err = m.read(func(q db.Querier) error {
+		if _, err := q.Exec("INSERT INTO users (email) VALUES ('dropped@example.com')"); err != nil {
+			return err
+		}
+		_, _, err := db.RunQuery(q, "SELECT * FROM missing")
+		return err
+	})
+	if err == nil {
+		t.Fatal("reading a missing table succeeded")
+	}
+
+	var emails []string
+	rows, err := s.tx.Query("SELECT email FROM users")
+	if err != nil {
+		t.Fatalf("transaction unusable after a failed read: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e string
+		rows.Scan(&e)
+		emails = append(emails, e)
+	}
+	if len(emails) != 1 || emails[0] != "kept@example.com" {
+		t.Errorf("rows in the transaction = %v, want only the one staged before the read", emails)
+	}
+}
+
+// An undo made while another table is shown is staged under its own table,
+// whose masks apply to it in the pending changes.
+func TestUndoStagedUnderItsTable(t *testing.T) {
+	cfg := config.DatabaseConfig{
+		Label:  "Local",
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "test.db"),
+		Masks:  []config.Mask{{Table: "users", Column: "email", Mode: "full"}},
+	}
+	conn, err := db.Connect(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.DB.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT); CREATE TABLE orders (order_id INTEGER PRIMARY KEY);" +
+		"INSERT INTO users VALUES (1, 'alice@example.com')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&config.Config{Connections: []config.DatabaseConfig{cfg}}, "", config.Access{})
+	s := newSession(cfg, conn)
+	m.openSession(s, true)
+	next, _ := m.beginTransaction()
+	m = next.(Model)
+	defer rollbackSession(s)
+
+	m.currentTable, m.pkColumn = "users", "id"
+	if err := m.deleteRecord("users", "id", 1); err != nil {
+		t.Fatal(err)
+	}
+	m.currentTable, m.pkColumn = "orders", "order_id"
+	next, _ = m.showUndoConfirm()
+	m = next.(Model)
+	m.confirmAction(&m)
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+
+	undo := s.pending[len(s.pending)-1]
+	if undo.kind != "UNDO" || undo.table != "users" || undo.keyCol != "id" {
+		t.Errorf("undo staged as %s on %s by %s, want UNDO on users by id", undo.kind, undo.table, undo.keyCol)
+	}
+	if strings.Contains(m.renderPending(), "alice") {
+		t.Error("the pending changes show the restored row's masked email")
+	}
+}
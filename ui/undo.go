@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qyinm/lazyadmin/audit"
+	"github.com/qyinm/lazyadmin/db"
+)
+
+// maxUndo is how many changes each session can undo.
+const maxUndo = 50
+
+// remember pushes change onto the active session's undo stack.
+func (m *Model) remember(change db.Change) {
+	s := m.activeSession()
+	if s == nil || !change.Undoable() {
+		return
+	}
+	s.undo = append(s.undo, change)
+	if len(s.undo) > maxUndo {
+		s.undo = s.undo[len(s.undo)-maxUndo:]
+		s.undoMark = max(s.undoMark-1, 0)
+	}
+}
+
+// dropUndone forgets the changes made in s's transaction once it is rolled
+// back.
+func (s *session) dropUndone() {
+	if s.undoMark < len(s.undo) {
+		s.undo = s.undo[:s.undoMark]
+	}
+}
+
+// describeChange names the row a change touched, e.g. "UPDATE of users id = 3".
+func describeChange(c db.Change) string {
+	return fmt.Sprintf("%s of %s %s = %v", c.Kind, c.Table, c.PKColumn, c.PKValue)
+}
+
+// showUndoConfirm asks before reversing the active session's last change.
+func (m Model) showUndoConfirm() (tea.Model, tea.Cmd) {
+	s := m.activeSession()
+	if s == nil || len(s.undo) == 0 {
+		m.statusMsg = "Nothing to undo"
+		return m, nil
+	}
+	if m.readOnly() {
+		return m.refuseWrite()
+	}
+
+	change := s.undo[len(s.undo)-1]
+	if err := m.allow(undoAction(change.Kind), change.Table); err != nil {
+		return m.deny(err)
+	}
+	stmt, err := change.UndoStatement(m.driver)
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	m.confirmMsg = fmt.Sprintf("Undo %s? (y/n)", describeChange(change))
+	m.confirmStmt = &stmt
+	m.confirmTable = change.Table
+	var cmd tea.Cmd
+	if m.production() {
+		m.confirmMsg = m.productionPreview(fmt.Sprintf("Undo %s?", describeChange(change)), stmt) +
+			fmt.Sprintf("\n\nType %q to confirm, or esc to cancel.", change.Table)
+		cmd = m.expectTyped(change.Table)
+	}
+	m.confirmReturn = m.focus
+	if m.confirmReturn == FocusConfirm || m.confirmReturn == FocusForm {
+		m.confirmReturn = FocusTable
+	}
+	m.confirmAction = func(m *Model) tea.Cmd {
+		s.undo = s.undo[:len(s.undo)-1]
+		s.undoMark = min(s.undoMark, len(s.undo))
+
+		var stmt db.Statement
+		err := m.write(func(q db.Querier) error {
+			var err error
+			stmt, err = change.Undo(q, m.driver)
+			return err
+		})
+		m.audit(audit.Entry{
+			Action: "UNDO " + change.Kind,
+			Table:  change.Table,
+			Key:    map[string]interface{}{change.PKColumn: change.PKValue},
+			Before: audit.Image(change.After),
+			After:  audit.Image(change.Before),
+			SQL:    stmt.SQL,
+			Args:   stmt.Args,
+		}, err)
+
+		switch {
+		case errors.Is(err, db.ErrConflict):
+			m.err = err
+			m.statusMsg = "Not undone, dropped from the undo stack: " + err.Error()
+		case err != nil:
+			// Put it back so the undo can be retried.
+			s.undo = append(s.undo, change)
+			m.err = err
+			m.statusMsg = "Undo failed: " + err.Error()
+		default:
+			m.stage("UNDO", change.Table, change.PKColumn, fmt.Sprint(change.PKValue), stmt)
+			m.err = nil
+			m.statusMsg = "Undid " + describeChange(change) + m.stagedSuffix()
+		}
+		return nil
+	}
+	m.focus = FocusConfirm
+	return m, cmd
+}